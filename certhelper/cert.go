@@ -0,0 +1,107 @@
+package certhelper
+
+// Certificate helpers for existing keys.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+)
+
+// CustomRootCAWithKey returns a custom self-signed x509 root CA for an
+// existing RSA or EC key, for example from a KeyPool or a KeyHandle. See
+// CustomCATemplate for the parameters.
+func CustomRootCAWithKey(commonName, orgUnit, serialNumber, countryCode string,
+	validity, maxPathLen int, keyUsage x509.KeyUsage, privKey crypto.Signer,
+	opts ...TemplateOption) (*x509.Certificate, error) {
+
+	algo, err := keyAlgo("privKey", privKey)
+	if err != nil {
+		return nil, err
+	}
+	// Get certificate template.
+	tmpl, err := CustomCATemplate(commonName, orgUnit, serialNumber, countryCode,
+		algo, validity, maxPathLen, keyUsage, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Create certificate's DER bytes.
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privKey.Public(), privKey)
+	if err != nil {
+		return nil, err
+	}
+	// Convert DER bytes to *x509.Certificate.
+	return x509.ParseCertificate(certDER)
+}
+
+// CustomLeafCertWithKey returns a custom leaf certificate for an existing RSA
+// or EC key, for example from a KeyPool or a KeyHandle. The certificate is
+// signed by caCert with caPrivKey, which can also be a KeyHandle.
+func CustomLeafCertWithKey(commonName, orgUnit, serialNumber, countryCode string,
+	validity int, privKey crypto.Signer, caCert *x509.Certificate,
+	caPrivKey interface{}, opts ...TemplateOption) (*x509.Certificate, error) {
+
+	// The signature algorithm depends on the CA's key.
+	algo, err := keyAlgo("caPrivKey", caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Get certificate template.
+	tmpl, err := CustomLeafTemplate(commonName, orgUnit, serialNumber,
+		countryCode, algo, validity, LeafKeyUsageConstant, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Create certificate's DER bytes.
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, privKey.Public(), caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Convert DER bytes to *x509.Certificate.
+	return x509.ParseCertificate(certDER)
+}
+
+// CustomIntermediateCAWithKey returns a custom intermediate CA for an existing
+// RSA or EC key signed by caCert with caPrivKey, which can also be a
+// KeyHandle. See CustomCATemplate for the parameters.
+func CustomIntermediateCAWithKey(commonName, orgUnit, serialNumber, countryCode string,
+	validity, maxPathLen int, keyUsage x509.KeyUsage, privKey crypto.Signer,
+	caCert *x509.Certificate, caPrivKey interface{},
+	opts ...TemplateOption) (*x509.Certificate, error) {
+
+	// The signature algorithm depends on the CA's key.
+	algo, err := keyAlgo("caPrivKey", caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Get certificate template.
+	tmpl, err := CustomCATemplate(commonName, orgUnit, serialNumber, countryCode,
+		algo, validity, maxPathLen, keyUsage, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Create certificate's DER bytes.
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, privKey.Public(), caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Convert DER bytes to *x509.Certificate.
+	return x509.ParseCertificate(certDER)
+}
+
+// keyAlgo returns the template algo ("RSA" or "EC") for privKey. privKey can
+// be any crypto.Signer with an RSA or EC public key, e.g., a KeyHandle. param
+// names privKey in errors.
+func keyAlgo(param string, privKey interface{}) (string, error) {
+	if signer, ok := privKey.(crypto.Signer); ok {
+		switch signer.Public().(type) {
+		case *rsa.PublicKey:
+			return "RSA", nil
+		case *ecdsa.PublicKey:
+			return "EC", nil
+		}
+	}
+	return "", &KeyTypeError{Param: param, Key: privKey}
+}
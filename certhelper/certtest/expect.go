@@ -0,0 +1,215 @@
+package certtest
+
+// Table-driven test helpers for certificate properties.
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parsiya/go-helpers/certhelper"
+)
+
+// Expectation describes the expected properties of a certificate. Zero values
+// are not checked except for IsCA.
+type Expectation struct {
+	// Name identifies the case in test output and in t.Run.
+	Name         string
+	CommonName   string
+	OrgUnit      string
+	SerialNumber string
+	CountryCode  string
+	// Validity is in years. NotBefore must not be in the future and NotAfter
+	// must be Validity years after NotBefore.
+	Validity int
+	// IsCA checks that the certificate is a CA with MaxPathLen if true and
+	// not a CA if false.
+	IsCA       bool
+	MaxPathLen int
+	KeyUsage   x509.KeyUsage
+	// KeySize is the RSA key size.
+	KeySize int
+	// Curve is the EC curve, e.g., "P256". See certhelper.ParseCurve.
+	Curve string
+}
+
+// Check returns an error that lists every property of cert that does not
+// match e.
+func (e Expectation) Check(cert *x509.Certificate) error {
+	if cert == nil {
+		return errors.New("certificate is nil")
+	}
+	var errs []string
+	mismatch := func(field string, got, want interface{}) {
+		errs = append(errs, fmt.Sprintf("%s: got %v, want %v", field, got, want))
+	}
+	if e.CommonName != "" && cert.Subject.CommonName != e.CommonName {
+		mismatch("CommonName", cert.Subject.CommonName, e.CommonName)
+	}
+	if e.OrgUnit != "" && !contains(cert.Subject.OrganizationalUnit, e.OrgUnit) {
+		mismatch("OrganizationalUnit", cert.Subject.OrganizationalUnit, e.OrgUnit)
+	}
+	if e.SerialNumber != "" {
+		if cert.Subject.SerialNumber != e.SerialNumber {
+			mismatch("Subject SerialNumber", cert.Subject.SerialNumber, e.SerialNumber)
+		}
+		if cert.SerialNumber == nil || cert.SerialNumber.String() != e.SerialNumber {
+			mismatch("SerialNumber", cert.SerialNumber, e.SerialNumber)
+		}
+	}
+	if e.CountryCode != "" && !contains(cert.Subject.Country, e.CountryCode) {
+		mismatch("Country", cert.Subject.Country, e.CountryCode)
+	}
+	if e.Validity != 0 {
+		if now := time.Now(); cert.NotBefore.After(now) {
+			mismatch("NotBefore", cert.NotBefore, "not after "+now.UTC().String())
+		}
+		// Allow a minute for the time between the two time.Now calls.
+		want := cert.NotBefore.AddDate(e.Validity, 0, 0)
+		if d := cert.NotAfter.Sub(want); d < -time.Minute || d > time.Minute {
+			mismatch("NotAfter", cert.NotAfter, want)
+		}
+	}
+	if cert.IsCA != e.IsCA || (e.IsCA && !cert.BasicConstraintsValid) {
+		mismatch("IsCA", cert.IsCA, e.IsCA)
+	}
+	if e.IsCA {
+		if cert.MaxPathLen != e.MaxPathLen {
+			mismatch("MaxPathLen", cert.MaxPathLen, e.MaxPathLen)
+		}
+	}
+	if e.KeyUsage != 0 && cert.KeyUsage != e.KeyUsage {
+		mismatch("KeyUsage", keyUsageString(cert.KeyUsage), keyUsageString(e.KeyUsage))
+	}
+	if e.KeySize != 0 {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok {
+			mismatch("PublicKey", fmt.Sprintf("%T", cert.PublicKey), "RSA")
+		} else if pub.N.BitLen() != e.KeySize {
+			mismatch("KeySize", pub.N.BitLen(), e.KeySize)
+		}
+	}
+	if e.Curve != "" {
+		curve, err := certhelper.ParseCurve(e.Curve)
+		if err != nil {
+			return err
+		}
+		if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+			mismatch("PublicKey", fmt.Sprintf("%T", cert.PublicKey), "EC")
+		} else if pub.Curve != curve.Elliptic() {
+			mismatch("Curve", pub.Curve.Params().Name, curve.Elliptic().Params().Name)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// AssertCert reports an error on t with every property of cert that does not
+// match e. See Expectation.Check.
+func AssertCert(t testing.TB, cert *x509.Certificate, e Expectation) {
+	t.Helper()
+	if err := e.Check(cert); err != nil {
+		if e.Name != "" {
+			t.Errorf("%s: %s", e.Name, err.Error())
+		} else {
+			t.Error(err.Error())
+		}
+	}
+}
+
+// CaseMatrix lists the values of each property. Cases returns every
+// combination. Empty lists use the zero value.
+type CaseMatrix struct {
+	CommonNames   []string
+	OrgUnits      []string
+	SerialNumbers []string
+	CountryCodes  []string
+	Validities    []int
+	// MaxPathLens are only used with IsCA.
+	MaxPathLens []int
+	IsCA        bool
+	KeyUsage    x509.KeyUsage
+	KeySizes    []int
+	Curves      []string
+}
+
+// Cases returns the Cartesian product of m. Each case has a Name made from
+// its values, e.g., "cname1_org1_1_US_P256_1_0", for t.Run.
+func (m CaseMatrix) Cases() []Expectation {
+	cases := []Expectation{{IsCA: m.IsCA, KeyUsage: m.KeyUsage}}
+	expand := func(n int, set func(e *Expectation, i int)) {
+		if n == 0 {
+			return
+		}
+		out := make([]Expectation, 0, len(cases)*n)
+		for _, c := range cases {
+			for i := 0; i < n; i++ {
+				e := c
+				set(&e, i)
+				out = append(out, e)
+			}
+		}
+		cases = out
+	}
+	expand(len(m.CommonNames), func(e *Expectation, i int) { e.CommonName = m.CommonNames[i] })
+	expand(len(m.OrgUnits), func(e *Expectation, i int) { e.OrgUnit = m.OrgUnits[i] })
+	expand(len(m.SerialNumbers), func(e *Expectation, i int) { e.SerialNumber = m.SerialNumbers[i] })
+	expand(len(m.CountryCodes), func(e *Expectation, i int) { e.CountryCode = m.CountryCodes[i] })
+	expand(len(m.KeySizes), func(e *Expectation, i int) { e.KeySize = m.KeySizes[i] })
+	expand(len(m.Curves), func(e *Expectation, i int) { e.Curve = m.Curves[i] })
+	expand(len(m.Validities), func(e *Expectation, i int) { e.Validity = m.Validities[i] })
+	if m.IsCA {
+		expand(len(m.MaxPathLens), func(e *Expectation, i int) { e.MaxPathLen = m.MaxPathLens[i] })
+	}
+
+	for i := range cases {
+		c := &cases[i]
+		var parts []string
+		for _, s := range []string{c.CommonName, c.OrgUnit, c.SerialNumber, c.CountryCode, c.Curve} {
+			if s != "" {
+				parts = append(parts, s)
+			}
+		}
+		if c.KeySize != 0 {
+			parts = append(parts, strconv.Itoa(c.KeySize))
+		}
+		if c.Validity != 0 {
+			parts = append(parts, strconv.Itoa(c.Validity))
+		}
+		if m.IsCA && len(m.MaxPathLens) > 0 {
+			parts = append(parts, strconv.Itoa(c.MaxPathLen))
+		}
+		c.Name = strings.Join(parts, "_")
+	}
+	return cases
+}
+
+var keyUsageNames = []string{"digitalSignature", "contentCommitment",
+	"keyEncipherment", "dataEncipherment", "keyAgreement", "keyCertSign",
+	"cRLSign", "encipherOnly", "decipherOnly"}
+
+func keyUsageString(ku x509.KeyUsage) string {
+	var names []string
+	for i, name := range keyUsageNames {
+		if ku&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
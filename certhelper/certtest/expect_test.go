@@ -0,0 +1,81 @@
+package certtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/parsiya/go-helpers/certhelper"
+)
+
+func TestCaseMatrix(t *testing.T) {
+	m := CaseMatrix{
+		CommonNames:   []string{"cname1", "cname2"},
+		SerialNumbers: []string{"1"},
+		Curves:        []string{"P256", "P384"},
+		Validities:    []int{1, 2},
+		MaxPathLens:   []int{0, 1},
+	}
+	cases := m.Cases()
+	if len(cases) != 8 {
+		t.Fatalf("bad number of cases, got %d, want 8", len(cases))
+	}
+	if cases[0].Name != "cname1_1_P256_1" || cases[7].Name != "cname2_1_P384_2" {
+		t.Errorf("bad case names, got %s and %s", cases[0].Name, cases[7].Name)
+	}
+
+	// MaxPathLens are used for CAs.
+	m.IsCA = true
+	cases = m.Cases()
+	if len(cases) != 16 || cases[1].Name != "cname1_1_P256_1_1" || !cases[1].IsCA {
+		t.Errorf("bad CA cases, got %d cases and %+v", len(cases), cases[1])
+	}
+	if cases := (CaseMatrix{}).Cases(); len(cases) != 1 {
+		t.Errorf("empty matrix should have one case, got %d", len(cases))
+	}
+}
+
+func TestExpectationCheck(t *testing.T) {
+	cert, _, err := certhelper.CustomECRootCA("cname1", "orgunit1", "1", "US", "P256", 2, 1,
+		certhelper.CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	e := Expectation{
+		Name:         "root",
+		CommonName:   "cname1",
+		OrgUnit:      "orgunit1",
+		SerialNumber: "1",
+		CountryCode:  "US",
+		Validity:     2,
+		IsCA:         true,
+		MaxPathLen:   1,
+		KeyUsage:     certhelper.CAKeyUsageConstant,
+		Curve:        "P256",
+	}
+	AssertCert(t, cert, e)
+
+	bad := e
+	bad.CommonName = "cname2"
+	bad.Validity = 1
+	bad.MaxPathLen = 0
+	bad.KeySize = 2048
+	err = bad.Check(cert)
+	if err == nil {
+		t.Fatalf("Check did not return an error")
+	}
+	for _, field := range []string{"CommonName", "NotAfter", "MaxPathLen", "PublicKey"} {
+		if !strings.Contains(err.Error(), field+": got") {
+			t.Errorf("error does not contain %s, got %s", field, err.Error())
+		}
+	}
+
+	// IsCA false is checked too.
+	leaf := e
+	leaf.IsCA = false
+	if err := leaf.Check(cert); err == nil || !strings.Contains(err.Error(), "IsCA: got true") {
+		t.Errorf("Check accepted a CA as a leaf, got %v", err)
+	}
+	if err := e.Check(nil); err == nil {
+		t.Errorf("Check(nil) did not return an error")
+	}
+}
@@ -0,0 +1,138 @@
+package certhelper
+
+// Look-alike certificates cloned from existing certificates.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+)
+
+// Extensions that CloneTemplate does not copy as raw extensions. The key
+// identifiers and CT data belong to the original key and issuer. The others are
+// copied as template fields so TemplateOptions can change them.
+var cloneSkippedExtensions = map[string]bool{
+	"2.5.29.14":               true, // Subject key identifier.
+	"2.5.29.15":               true, // Key usage.
+	"2.5.29.17":               true, // Subject alternative name.
+	"2.5.29.19":               true, // Basic constraints.
+	"2.5.29.31":               true, // CRL distribution points.
+	"2.5.29.35":               true, // Authority key identifier.
+	"2.5.29.37":               true, // Extended key usage.
+	"1.3.6.1.5.5.7.1.1":       true, // Authority information access.
+	"1.3.6.1.4.1.11129.2.4.2": true, // Embedded SCTs.
+	"1.3.6.1.4.1.11129.2.4.3": true, // CT precertificate poison.
+}
+
+// CloneTemplate returns a template that looks like cert. The serial number,
+// subject, validity, SANs, key usages, basic constraints, CRL and AIA URLs
+// are copied to the template fields. Other extensions, e.g., certificate
+// policies and name constraints, are copied as is. The key identifiers and CT
+// extensions are not copied.
+// The subject is copied to RawSubject to keep its encoding, set RawSubject to
+// nil to change Subject.
+func CloneTemplate(cert *x509.Certificate) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber:          cert.SerialNumber,
+		RawSubject:            cert.RawSubject,
+		Subject:               cert.Subject,
+		NotBefore:             cert.NotBefore,
+		NotAfter:              cert.NotAfter,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           append([]x509.ExtKeyUsage(nil), cert.ExtKeyUsage...),
+		UnknownExtKeyUsage:    cert.UnknownExtKeyUsage,
+		BasicConstraintsValid: cert.BasicConstraintsValid,
+		IsCA:                  cert.IsCA,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		DNSNames:              append([]string(nil), cert.DNSNames...),
+		EmailAddresses:        append([]string(nil), cert.EmailAddresses...),
+		CRLDistributionPoints: append([]string(nil), cert.CRLDistributionPoints...),
+		OCSPServer:            append([]string(nil), cert.OCSPServer...),
+		IssuingCertificateURL: append([]string(nil), cert.IssuingCertificateURL...),
+	}
+	for _, ip := range cert.IPAddresses {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, append(net.IP(nil), ip...))
+	}
+	for _, u := range cert.URIs {
+		uri := *u
+		tmpl.URIs = append(tmpl.URIs, &uri)
+	}
+	for _, ext := range cert.Extensions {
+		if !cloneSkippedExtensions[ext.Id.String()] {
+			tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{
+				Id:       ext.Id,
+				Critical: ext.Critical,
+				Value:    ext.Value,
+			})
+		}
+	}
+	return tmpl
+}
+
+// CloneKey generates a new key with the type and size of cert's public key.
+// Supports RSA, EC and Ed25519 keys.
+func CloneKey(cert *x509.Certificate) (crypto.Signer, error) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return rsaKey(pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		curve, err := ParseCurve(pub.Curve.Params().Name)
+		if err != nil {
+			return nil, err
+		}
+		return sourceECKey(curve.String())
+	case ed25519.PublicKey:
+		_, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, err
+	default:
+		return nil, &KeyTypeError{Param: "certificate public key", Key: cert.PublicKey}
+	}
+}
+
+// CloneCert returns a look-alike of cert with a new key of the same type
+// signed by caCert with caPrivKey. If caCert is nil, the clone is
+// self-signed. See CloneTemplate for the copied fields.
+func CloneCert(cert, caCert *x509.Certificate,
+	caPrivKey interface{}) (*x509.Certificate, crypto.Signer, error) {
+
+	privKey, err := CloneKey(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	clone, err := CustomCloneCert(cert, privKey, caCert, caPrivKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clone, privKey, nil
+}
+
+// CustomCloneCert returns a look-alike of cert for privKey signed by caCert
+// with caPrivKey. If caCert is nil, the clone is self-signed with privKey and
+// caPrivKey is ignored. opts are applied to the template from CloneTemplate.
+// The signature algorithm is picked by Go based on the signing key.
+func CustomCloneCert(cert *x509.Certificate, privKey crypto.Signer,
+	caCert *x509.Certificate, caPrivKey interface{},
+	opts ...TemplateOption) (*x509.Certificate, error) {
+
+	tmpl := CloneTemplate(cert)
+	if err := applyOptions(tmpl, opts); err != nil {
+		return nil, err
+	}
+	parent := caCert
+	if caCert == nil {
+		parent, caPrivKey = tmpl, privKey
+	}
+	// Create certificate's DER bytes.
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, parent, privKey.Public(), caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Convert DER bytes to *x509.Certificate.
+	return x509.ParseCertificate(certDER)
+}
@@ -0,0 +1,485 @@
+package certhelper
+
+// CMS (RFC 5652) SignedData and PKCS#7 certificate bundles.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA512                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidRSAEncryption          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidEd25519                = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// SignCMS returns a DER encoded CMS SignedData of content signed with cert and
+// privKey. privKey can be an RSA, EC or Ed25519 key. chain is included in the
+// certificates field after cert and can be empty. If detached is true, content
+// is not included and must be passed to VerifyCMS separately.
+func SignCMS(content []byte, cert *x509.Certificate, privKey interface{},
+	chain []*x509.Certificate, detached bool) ([]byte, error) {
+
+	sd, err := newSignedData(content, oidData, cert, privKey, chain, detached)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSignedData(*sd)
+}
+
+// VerifyCMS verifies a DER encoded CMS SignedData. content must be set for
+// detached signatures and nil otherwise. Signer certificates are verified
+// against roots (the system roots if nil) with the embedded certificates as
+// intermediates. Returns the signed content and the signer certificates.
+func VerifyCMS(der, content []byte, roots *x509.CertPool) ([]byte, []*x509.Certificate, error) {
+	content, signers, certs, err := checkCMSSignature(der, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range certs {
+		opts.Intermediates.AddCert(c)
+	}
+	for _, cert := range signers {
+		if _, err := cert.Verify(opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	return content, signers, nil
+}
+
+// CheckCMSSignature checks the signatures of a DER encoded CMS SignedData
+// like VerifyCMS but does not verify the signer certificates. Anyone can sign
+// with their own certificate, only use it if the signers are verified
+// separately.
+func CheckCMSSignature(der, content []byte) ([]byte, []*x509.Certificate, error) {
+	content, signers, _, err := checkCMSSignature(der, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	return content, signers, nil
+}
+
+// checkCMSSignature checks the signatures in der and returns the signed
+// content, the signer certificates and all embedded certificates.
+func checkCMSSignature(der, content []byte) ([]byte, []*x509.Certificate,
+	[]*x509.Certificate, error) {
+
+	sd, certs, err := parseSignedData(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(sd.EncapContentInfo.EContent.Bytes) > 0 {
+		if content != nil {
+			return nil, nil, nil, fmt.Errorf("content passed for an attached signature")
+		}
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+			return nil, nil, nil, err
+		}
+	} else if content == nil {
+		return nil, nil, nil, fmt.Errorf("content is required for a detached signature")
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, nil, nil, fmt.Errorf("no signers")
+	}
+
+	var signers []*x509.Certificate
+	for _, si := range sd.SignerInfos {
+		cert := findSigner(certs, si.SID)
+		if cert == nil {
+			return nil, nil, nil, fmt.Errorf("signer certificate not found")
+		}
+		if err := verifySignerInfo(si, cert, sd.EncapContentInfo.EContentType, content); err != nil {
+			return nil, nil, nil, err
+		}
+		signers = append(signers, cert)
+	}
+	return content, signers, certs, nil
+}
+
+// CertsToPKCS7 returns a DER encoded degenerate (certificates-only) PKCS#7
+// SignedData, also known as a .p7b file.
+func CertsToPKCS7(certs ...*x509.Certificate) ([]byte, error) {
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     certificatesField(certs),
+		SignerInfos:      []signerInfo{},
+	}
+	return marshalSignedData(sd)
+}
+
+// CertsToPKCS7File stores a PEM encoded certificates-only PKCS#7 in a file.
+func CertsToPKCS7File(certs []*x509.Certificate, filename string) error {
+	der, err := CertsToPKCS7(certs...)
+	if err != nil {
+		return err
+	}
+	p := pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der})
+	if p == nil {
+		return &EncodingError{Format: "PEM"}
+	}
+	// Do not overwrite the file.
+	return writeFile(p, filename, false)
+}
+
+// ParsePKCS7Certs returns the certificates in a DER or PEM encoded PKCS#7.
+func ParsePKCS7Certs(data []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	_, certs, err := parseSignedData(data)
+	return certs, err
+}
+
+// newSignedData returns a SignedData with one signer for content of type
+// contentType. extraAttrs are added to the signed attributes.
+func newSignedData(content []byte, contentType asn1.ObjectIdentifier,
+	cert *x509.Certificate, privKey interface{}, chain []*x509.Certificate,
+	detached bool, extraAttrs ...attribute) (*signedData, error) {
+
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+	digestAlg, sigAlg, hash, err := cmsAlgorithms(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// Signed attributes.
+	h := hash.New()
+	h.Write(content)
+	var signedAttrs []attribute
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttributeContentType, contentType},
+		{oidAttributeMessageDigest, h.Sum(nil)},
+		{oidAttributeSigningTime, time.Now().UTC()},
+	} {
+		attr, err := attributeValue(a.oid, a.value)
+		if err != nil {
+			return nil, err
+		}
+		signedAttrs = append(signedAttrs, attr)
+	}
+	attrs, err := marshalAttributes(append(signedAttrs, extraAttrs...)...)
+	if err != nil {
+		return nil, err
+	}
+	// The signature is over the DER SET OF, not the [0] IMPLICIT encoding.
+	sig, err := cmsSign(signer, hash, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Version 3 is required for content types other than id-data.
+	version := 1
+	if !contentType.Equal(oidData) {
+		version = 3
+	}
+	sd := signedData{
+		Version:          version,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: encapContentInfo{EContentType: contentType},
+		Certificates:     certificatesField(append([]*x509.Certificate{cert}, chain...)),
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:    digestAlg,
+			SignedAttrs:        implicitAttributes(0, attrs),
+			SignatureAlgorithm: sigAlg,
+			Signature:          sig,
+		}},
+	}
+	if !detached {
+		octets, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sd.EncapContentInfo.EContent = explicitField(octets)
+	}
+	return &sd, nil
+}
+
+// cmsAlgorithms returns the digest and signature algorithms for pub.
+func cmsAlgorithms(pub crypto.PublicKey) (digestAlg, sigAlg pkix.AlgorithmIdentifier,
+	hash crypto.Hash, err error) {
+
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			crypto.SHA256, nil
+	case ed25519.PublicKey:
+		// RFC 8419.
+		return pkix.AlgorithmIdentifier{Algorithm: oidSHA512},
+			pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			crypto.SHA512, nil
+	default:
+		return digestAlg, sigAlg, 0, &KeyTypeError{Param: "public key", Key: pub}
+	}
+}
+
+// cmsSign signs data with signer.
+func cmsSign(signer crypto.Signer, hash crypto.Hash, data []byte) ([]byte, error) {
+	// Ed25519 signs the message, not a digest.
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	h := hash.New()
+	h.Write(data)
+	return signer.Sign(rand.Reader, h.Sum(nil), hash)
+}
+
+// cmsVerify verifies sig over data with pub.
+func cmsVerify(pub crypto.PublicKey, hash crypto.Hash, data, sig []byte) error {
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, data, sig) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return &KeyTypeError{Param: "public key", Key: pub}
+	}
+}
+
+// verifySignerInfo verifies the signed attributes and signature of si for
+// content of type contentType. The digest algorithm of si is used, e.g.,
+// SHA-384 from OpenSSL.
+func verifySignerInfo(si signerInfo, cert *x509.Certificate,
+	contentType asn1.ObjectIdentifier, content []byte) error {
+
+	hash, err := cmsHash(si.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		// Without signed attributes the signature is over the content.
+		return cmsVerify(cert.PublicKey, hash, content, si.Signature)
+	}
+	// Signed attributes are signed as a SET OF.
+	attrs := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	parsed, err := parseAttributes(si.SignedAttrs)
+	if err != nil {
+		return err
+	}
+	// Both attributes are required if there are signed attributes.
+	var digest []byte
+	var signedType asn1.ObjectIdentifier
+	for _, a := range parsed {
+		switch {
+		case a.Type.Equal(oidAttributeMessageDigest):
+			err = singleAttributeValue(a, &digest, digest != nil)
+		case a.Type.Equal(oidAttributeContentType):
+			err = singleAttributeValue(a, &signedType, signedType != nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !signedType.Equal(contentType) {
+		return fmt.Errorf("content type mismatch, got %s, want %s", signedType, contentType)
+	}
+	h := hash.New()
+	h.Write(content)
+	if digest == nil || !bytes.Equal(digest, h.Sum(nil)) {
+		return fmt.Errorf("message digest mismatch")
+	}
+	return cmsVerify(cert.PublicKey, hash, attrs, si.Signature)
+}
+
+// singleAttributeValue unmarshals the only value of a into out. seen is true
+// if the attribute was already parsed.
+func singleAttributeValue(a attribute, out interface{}, seen bool) error {
+	if seen || len(a.Values) != 1 {
+		return fmt.Errorf("attribute %s must have one value", a.Type)
+	}
+	_, err := asn1.Unmarshal(a.Values[0].FullBytes, out)
+	return err
+}
+
+// cmsHash returns the hash of a SignerInfo digest algorithm. SHA-1 is not
+// supported.
+func cmsHash(alg pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	hash, err := oidToHash(alg.Algorithm)
+	if err != nil {
+		return 0, err
+	}
+	if hash == crypto.SHA1 {
+		return 0, fmt.Errorf("%w: SHA-1 signatures", ErrUnsupportedAlgorithm)
+	}
+	return hash, nil
+}
+
+// findSigner returns the certificate identified by sid.
+func findSigner(certs []*x509.Certificate, sid issuerAndSerial) *x509.Certificate {
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, sid.Issuer.FullBytes) && c.SerialNumber.Cmp(sid.SerialNumber) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+// attributeValue returns an attribute with one value.
+func attributeValue(oid asn1.ObjectIdentifier, value interface{}) (attribute, error) {
+	b, err := asn1.Marshal(value)
+	if err != nil {
+		return attribute{}, err
+	}
+	return attribute{Type: oid, Values: []asn1.RawValue{{FullBytes: b}}}, nil
+}
+
+// marshalAttributes returns the DER SET OF attrs.
+func marshalAttributes(attrs ...attribute) ([]byte, error) {
+	return asn1.MarshalWithParams(attrs, "set")
+}
+
+// implicitAttributes returns the [tag] IMPLICIT encoding of a DER SET OF
+// attributes.
+func implicitAttributes(tag byte, attrs []byte) asn1.RawValue {
+	return asn1.RawValue{FullBytes: append([]byte{0xa0 | tag}, attrs[1:]...)}
+}
+
+// parseAttributes parses [n] IMPLICIT attributes from a SignerInfo.
+func parseAttributes(raw asn1.RawValue) ([]attribute, error) {
+	// Attributes are a SET OF with an implicit tag.
+	attrs := append([]byte{0x31}, raw.FullBytes[1:]...)
+	var parsed []attribute
+	if _, err := asn1.UnmarshalWithParams(attrs, &parsed, "set"); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// certificatesField returns the [0] IMPLICIT SET OF Certificate field.
+func certificatesField(certs []*x509.Certificate) asn1.RawValue {
+	var b []byte
+	for _, c := range certs {
+		b = append(b, c.Raw...)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+// marshalSignedData wraps sd in a ContentInfo.
+func marshalSignedData(sd signedData) ([]byte, error) {
+	b, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     explicitField(b),
+	})
+}
+
+// explicitField returns an [0] EXPLICIT field with der inside. Marshal does
+// not add the explicit tag for RawValues.
+func explicitField(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// parseSignedData parses a ContentInfo with a SignedData and returns the
+// embedded certificates.
+func parseSignedData(der []byte) (*signedData, []*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("content type is not SignedData, got %s", ci.ContentType)
+	}
+	var sd signedData
+	// Content has the explicit [0] tag, Bytes is the SignedData.
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, err
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &sd, certs, nil
+}
@@ -0,0 +1,204 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestSignCMS(t *testing.T) {
+	rsaRoot, rsaRootKey, err := RSARootCA("root1", "org1", "1", "US", 2048)
+	if err != nil {
+		t.Fatalf("error creating RSA root CA: %s", err.Error())
+	}
+	rsaLeaf, rsaKey, err := CustomRSALeafCert("leaf1", "org1", "2", "US", 1, 2048,
+		rsaRoot, rsaRootKey)
+	if err != nil {
+		t.Fatalf("error creating RSA leaf: %s", err.Error())
+	}
+	ecRoot, ecRootKey, err := ECRootCA("root2", "org1", "3", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating EC root CA: %s", err.Error())
+	}
+	ecLeaf, ecKey, err := CustomECLeafCert("leaf2", "org1", "4", "US", "P384", 1,
+		ecRoot, ecRootKey)
+	if err != nil {
+		t.Fatalf("error creating EC leaf: %s", err.Error())
+	}
+
+	content := []byte("document to sign")
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		key      interface{}
+		root     *x509.Certificate
+		detached bool
+	}{
+		{"rsa-attached", rsaLeaf, rsaKey, rsaRoot, false},
+		{"rsa-detached", rsaLeaf, rsaKey, rsaRoot, true},
+		{"ec-attached", ecLeaf, ecKey, ecRoot, false},
+		{"ec-detached", ecLeaf, ecKey, ecRoot, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := SignCMS(content, tt.cert, tt.key, nil, tt.detached)
+			if err != nil {
+				t.Fatalf("SignCMS error: %s", err.Error())
+			}
+			roots := x509.NewCertPool()
+			roots.AddCert(tt.root)
+			var detachedContent []byte
+			if tt.detached {
+				detachedContent = content
+			}
+			got, signers, err := VerifyCMS(der, detachedContent, roots)
+			if err != nil {
+				t.Fatalf("VerifyCMS error: %s", err.Error())
+			}
+			if !bytes.Equal(got, content) || !signers[0].Equal(tt.cert) {
+				t.Errorf("VerifyCMS returned %q and %v", got, signers)
+			}
+			if tt.detached {
+				if _, _, err := VerifyCMS(der, []byte("tampered"), roots); err == nil {
+					t.Errorf("VerifyCMS accepted tampered content")
+				}
+			}
+		})
+	}
+}
+
+// signCMSWithHash returns an attached SignedData of content signed with hash
+// and signed attributes for signedType, like OpenSSL with -md.
+func signCMSWithHash(t *testing.T, content []byte, cert *x509.Certificate,
+	key crypto.Signer, hash crypto.Hash, signedType asn1.ObjectIdentifier) []byte {
+
+	h := hash.New()
+	h.Write(content)
+	contentType, err := attributeValue(oidAttributeContentType, signedType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := attributeValue(oidAttributeMessageDigest, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := marshalAttributes(contentType, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := cmsSign(key, hash, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashOID, err := hashToOID(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: hashOID}
+	der, err := marshalSignedData(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		EncapContentInfo: encapContentInfo{EContentType: oidData, EContent: explicitField(octets)},
+		Certificates:     certificatesField([]*x509.Certificate{cert}),
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			SID: issuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: digestAlg,
+			SignedAttrs:     implicitAttributes(0, attrs),
+			// ecdsa-with-SHA384, verification uses the digest algorithm.
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}},
+			Signature: sig,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestVerifyCMSDigestAlgorithm(t *testing.T) {
+	root, rootKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, key, err := CustomECLeafCert("leaf1", "org1", "2", "US", "P384", 1,
+		root, rootKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	content := []byte("document to sign")
+
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		der := signCMSWithHash(t, content, leaf, key, hash, oidData)
+		if got, _, err := VerifyCMS(der, nil, roots); err != nil || !bytes.Equal(got, content) {
+			t.Errorf("%s: VerifyCMS error: %v", hash, err)
+		}
+	}
+	der := signCMSWithHash(t, content, leaf, key, crypto.SHA1, oidData)
+	if _, _, err := VerifyCMS(der, nil, roots); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("VerifyCMS accepted SHA-1, got %v", err)
+	}
+	// The signed content type must match the encapsulated content type.
+	der = signCMSWithHash(t, content, leaf, key, crypto.SHA256, oidTSTInfo)
+	if _, _, err := VerifyCMS(der, nil, roots); err == nil {
+		t.Errorf("VerifyCMS accepted a content type mismatch")
+	}
+}
+
+func TestVerifyCMSUntrustedSigner(t *testing.T) {
+	// A signer with its own self-signed certificate.
+	cert, key, err := ECRootCA("attacker", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	content := []byte("document to sign")
+	der, err := SignCMS(content, cert, key, nil, false)
+	if err != nil {
+		t.Fatalf("SignCMS error: %s", err.Error())
+	}
+	for _, roots := range []*x509.CertPool{nil, x509.NewCertPool()} {
+		if _, _, err := VerifyCMS(der, nil, roots); err == nil {
+			t.Errorf("VerifyCMS accepted an untrusted signer")
+		}
+	}
+	got, signers, err := CheckCMSSignature(der, nil)
+	if err != nil || !bytes.Equal(got, content) || !signers[0].Equal(cert) {
+		t.Errorf("CheckCMSSignature returned %q, %v", got, err)
+	}
+}
+
+func TestCertsToPKCS7(t *testing.T) {
+	root, rootKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, _, err := ECLeafCert("leaf1", "org1", "2", "US", "P256", root, rootKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	der, err := CertsToPKCS7(leaf, root)
+	if err != nil {
+		t.Fatalf("CertsToPKCS7 error: %s", err.Error())
+	}
+	certs, err := ParsePKCS7Certs(der)
+	if err != nil {
+		t.Fatalf("ParsePKCS7Certs error: %s", err.Error())
+	}
+	if len(certs) != 2 || !certs[0].Equal(leaf) || !certs[1].Equal(root) {
+		t.Errorf("ParsePKCS7Certs returned %d certificates", len(certs))
+	}
+}
@@ -0,0 +1,164 @@
+package certhelper
+
+// Code signing certificates and detached signatures.
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// SignatureInfo is the result of verifying a detached signature.
+type SignatureInfo struct {
+	Signer *x509.Certificate
+	// Chain is the verified chain from Signer to a root.
+	Chain []*x509.Certificate
+	// Timestamp is nil if the signature does not have a timestamp token.
+	Timestamp *TimestampInfo
+}
+
+// WithCodeSigning sets the code signing profile: digital signature key usage
+// and the code signing extended key usage.
+func WithCodeSigning() TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		return nil
+	}
+}
+
+// SignDetached returns a DER encoded detached CMS signature of content with
+// cert and privKey. chain is included after cert and can be empty. If tsa is
+// not nil, a timestamp token over the signature value is added as an unsigned
+// attribute.
+func SignDetached(content []byte, cert *x509.Certificate, privKey interface{},
+	chain []*x509.Certificate, tsa Timestamper) ([]byte, error) {
+
+	sd, err := newSignedData(content, oidData, cert, privKey, chain, true)
+	if err != nil {
+		return nil, err
+	}
+	if tsa != nil {
+		digest := sha256.Sum256(sd.SignerInfos[0].Signature)
+		token, err := tsa.Timestamp(digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp failed: %w", err)
+		}
+		attrs, err := marshalAttributes(attribute{
+			Type:   oidAttributeTimeStampToken,
+			Values: []asn1.RawValue{{FullBytes: token}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		sd.SignerInfos[0].UnsignedAttrs = implicitAttributes(1, attrs)
+	}
+	return marshalSignedData(*sd)
+}
+
+// VerifyDetached verifies a detached signature of content created by
+// SignDetached. The signer must have the code signing extended key usage and
+// chain to roots (the system roots if nil). If the signature has a timestamp
+// token that verifies against tsaRoots (roots if nil), the signer is verified
+// at the time in the token so signatures stay valid after the signer expires.
+// Other tokens are ignored and the signer is verified at the current time.
+func VerifyDetached(content, sig []byte, roots, tsaRoots *x509.CertPool) (*SignatureInfo, error) {
+	// The signer is verified below with the code signing usage.
+	if _, _, err := CheckCMSSignature(sig, content); err != nil {
+		return nil, err
+	}
+	sd, certs, err := parseSignedData(sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("signature must have one signer, got %d", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	info := SignatureInfo{Signer: findSigner(certs, si.SID)}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	for _, c := range certs {
+		opts.Intermediates.AddCert(c)
+	}
+	token, err := timestampToken(si)
+	if err != nil {
+		return nil, err
+	}
+	if token != nil {
+		if tsaRoots == nil {
+			tsaRoots = roots
+		}
+		digest := sha256.Sum256(si.Signature)
+		if ts, err := VerifyTimestampToken(token, digest[:], crypto.SHA256, tsaRoots); err == nil {
+			info.Timestamp = ts
+			opts.CurrentTime = ts.Time
+		}
+	}
+	chains, err := info.Signer.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+	info.Chain = chains[0]
+	return &info, nil
+}
+
+// SignFile signs filename with SignDetached and stores the signature in
+// sigFile. sigFile is not overwritten.
+func SignFile(filename, sigFile string, cert *x509.Certificate, privKey interface{},
+	chain []*x509.Certificate, tsa Timestamper) error {
+
+	content, err := filehelper.ReadFileByte(filename)
+	if err != nil {
+		return err
+	}
+	sig, err := SignDetached(content, cert, privKey, chain, tsa)
+	if err != nil {
+		return err
+	}
+	return writeFile(sig, sigFile, false)
+}
+
+// VerifyFile verifies the DER or PEM signature in sigFile over filename with
+// VerifyDetached.
+func VerifyFile(filename, sigFile string, roots, tsaRoots *x509.CertPool) (*SignatureInfo, error) {
+	content, err := filehelper.ReadFileByte(filename)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := filehelper.ReadFileByte(sigFile)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(sig); block != nil {
+		sig = block.Bytes
+	}
+	return VerifyDetached(content, sig, roots, tsaRoots)
+}
+
+// timestampToken returns the timestamp token in si's unsigned attributes or
+// nil.
+func timestampToken(si signerInfo) ([]byte, error) {
+	if len(si.UnsignedAttrs.FullBytes) == 0 {
+		return nil, nil
+	}
+	attrs, err := parseAttributes(si.UnsignedAttrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		if a.Type.Equal(oidAttributeTimeStampToken) && len(a.Values) == 1 {
+			return a.Values[0].FullBytes, nil
+		}
+	}
+	return nil, nil
+}
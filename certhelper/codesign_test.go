@@ -0,0 +1,142 @@
+package certhelper
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSignDetached(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	cert, key, err := CustomECLeafCert("signer1", "org1", "2", "US", "P256", 1,
+		caCert, caPrivKey, WithCodeSigning())
+	if err != nil {
+		t.Fatalf("error creating code signing certificate: %s", err.Error())
+	}
+	content := []byte("build artefact")
+
+	sig, err := SignDetached(content, cert, key, nil, nil)
+	if err != nil {
+		t.Fatalf("SignDetached error: %s", err.Error())
+	}
+	info, err := VerifyDetached(content, sig, roots, nil)
+	if err != nil {
+		t.Fatalf("VerifyDetached error: %s", err.Error())
+	}
+	if !info.Signer.Equal(cert) || len(info.Chain) != 2 || info.Timestamp != nil {
+		t.Errorf("bad signature info: %+v", info)
+	}
+	if _, err := VerifyDetached([]byte("tampered"), sig, roots, nil); err == nil {
+		t.Errorf("signature verified for different content")
+	}
+
+	// Certificates without the code signing usage are rejected.
+	leaf, leafKey, err := CustomECLeafCert("leaf1", "org1", "3", "US", "P256", 1,
+		caCert, caPrivKey, WithExtKeyUsage(x509.ExtKeyUsageServerAuth))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	sig, err = SignDetached(content, leaf, leafKey, nil, nil)
+	if err != nil {
+		t.Fatalf("SignDetached error: %s", err.Error())
+	}
+	if _, err := VerifyDetached(content, sig, roots, nil); err == nil {
+		t.Errorf("signature verified without code signing usage")
+	}
+}
+
+func TestSignDetachedTimestamp(t *testing.T) {
+	caCert, _, tsa := testTSA(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	codeCA, codeCAKey, err := ECRootCA("root2", "org1", "10", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	codeRoots := x509.NewCertPool()
+	codeRoots.AddCert(codeCA)
+
+	// The signer expires right after signing.
+	expiring := func(c *x509.Certificate) error {
+		c.NotBefore = time.Now().Add(-time.Hour)
+		c.NotAfter = time.Now().Add(2 * time.Second)
+		return nil
+	}
+	cert, key, err := CustomECLeafCert("signer1", "org1", "11", "US", "P256", 1,
+		codeCA, codeCAKey, WithCodeSigning(), expiring)
+	if err != nil {
+		t.Fatalf("error creating code signing certificate: %s", err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "artefact.bin")
+	if err := ioutil.WriteFile(file, []byte("build artefact"), 0644); err != nil {
+		t.Fatalf("error writing file: %s", err.Error())
+	}
+	if err := SignFile(file, file+".p7s", cert, key, nil, tsa); err != nil {
+		t.Fatalf("SignFile error: %s", err.Error())
+	}
+	if err := SignFile(file, file+".nots.p7s", cert, key, nil, nil); err != nil {
+		t.Fatalf("SignFile error: %s", err.Error())
+	}
+	time.Sleep(3 * time.Second)
+
+	info, err := VerifyFile(file, file+".p7s", codeRoots, roots)
+	if err != nil {
+		t.Fatalf("VerifyFile error for an expired but timestamped signer: %s", err.Error())
+	}
+	if info.Timestamp == nil || !info.Timestamp.Cert.Equal(tsa.Cert) {
+		t.Errorf("missing timestamp info")
+	}
+	if _, err := VerifyFile(file, file+".nots.p7s", codeRoots, roots); err == nil {
+		t.Errorf("verified an expired signer without a timestamp")
+	}
+	// The TSA does not chain to codeRoots, the token is ignored.
+	if _, err := VerifyFile(file, file+".p7s", codeRoots, nil); err == nil {
+		t.Errorf("verified a timestamp with the wrong roots")
+	}
+}
+
+func TestSignDetachedUntrustedTSA(t *testing.T) {
+	// The TSA chains to a root that is not trusted.
+	_, _, tsa := testTSA(t)
+	caCert, caPrivKey, err := ECRootCA("root2", "org1", "10", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	cert, key, err := CustomECLeafCert("signer1", "org1", "11", "US", "P256", 1,
+		caCert, caPrivKey, WithCodeSigning())
+	if err != nil {
+		t.Fatalf("error creating code signing certificate: %s", err.Error())
+	}
+	content := []byte("build artefact")
+	sig, err := SignDetached(content, cert, key, nil, tsa)
+	if err != nil {
+		t.Fatalf("SignDetached error: %s", err.Error())
+	}
+
+	// The token is ignored with the signer's roots and the system roots.
+	for _, tsaRoots := range []*x509.CertPool{nil, roots} {
+		info, err := VerifyDetached(content, sig, roots, tsaRoots)
+		if err != nil {
+			t.Fatalf("VerifyDetached error: %s", err.Error())
+		}
+		if info.Timestamp != nil {
+			t.Errorf("used a timestamp from an untrusted TSA")
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package certhelper
 
-import "crypto/x509"
+import (
+	"crypto/x509"
+	"time"
+)
 
 // Constants.
 
@@ -13,4 +16,25 @@ var (
 	MaxPathLenConstant = 0
 	// CA key usage.
 	CAKeyUsageConstant = x509.KeyUsageCertSign
+	// Roots that cross-sign other roots allow one intermediate.
+	CrossSignMaxPathLenConstant = 1
+)
+
+// SSHUserExtensionsConstant is the default set of SSH user certificate
+// extensions. Same as the defaults used by ssh-keygen.
+var SSHUserExtensionsConstant = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+var (
+	// Certificates are renewed when less than 30% of their lifetime is left.
+	RenewBeforeConstant = 0.3
+	// Rotators check their certificates every minute.
+	RotationCheckIntervalConstant = time.Minute
+	// LocalCA certificates are valid for 90 days like most ACME CAs.
+	IssuedLifetimeConstant = 90 * 24 * time.Hour
 )
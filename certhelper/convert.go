@@ -0,0 +1,279 @@
+package certhelper
+
+// DER, PEM and base64 conversion.
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// PEM block types.
+const (
+	PEMCertificate   = "CERTIFICATE"
+	PEMCSR           = "CERTIFICATE REQUEST"
+	PEMCRL           = "X509 CRL"
+	PEMPublicKey     = "PUBLIC KEY"
+	PEMRSAPublicKey  = "RSA PUBLIC KEY"
+	PEMPrivateKey    = "PRIVATE KEY"
+	PEMRSAPrivateKey = "RSA PRIVATE KEY"
+	PEMECPrivateKey  = "EC PRIVATE KEY"
+)
+
+// Format is an encoding of certificates, CSRs, CRLs and keys.
+type Format int
+
+// Supported formats.
+const (
+	FormatUnknown Format = iota
+	FormatDER
+	FormatPEM
+	// FormatBase64 is base64 encoded DER without PEM headers.
+	FormatBase64
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatDER:
+		return "DER"
+	case FormatPEM:
+		return "PEM"
+	case FormatBase64:
+		return "base64"
+	default:
+		return "unknown"
+	}
+}
+
+// DetectFormat returns the format of data.
+func DetectFormat(data []byte) Format {
+	if bytes.Contains(data, []byte("-----BEGIN ")) {
+		return FormatPEM
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(data, &raw); err == nil && len(rest) == 0 &&
+		raw.Tag == asn1.TagSequence {
+		return FormatDER
+	}
+	if _, err := decodeBase64(data); err == nil {
+		return FormatBase64
+	}
+	return FormatUnknown
+}
+
+// DERType returns the PEM block type of a DER encoded certificate, CSR, CRL,
+// public key or private key.
+func DERType(der []byte) (string, error) {
+	if _, err := x509.ParseCertificate(der); err == nil {
+		return PEMCertificate, nil
+	}
+	if _, err := x509.ParseCertificateRequest(der); err == nil {
+		return PEMCSR, nil
+	}
+	if _, err := x509.ParseRevocationList(der); err == nil {
+		return PEMCRL, nil
+	}
+	if _, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return PEMPublicKey, nil
+	}
+	if _, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return PEMRSAPublicKey, nil
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return PEMPrivateKey, nil
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return PEMRSAPrivateKey, nil
+	}
+	if _, err := x509.ParseECPrivateKey(der); err == nil {
+		return PEMECPrivateKey, nil
+	}
+	return "", fmt.Errorf("%w: unknown DER object", ErrInvalidFormat)
+}
+
+// DecodeBlocks decodes DER, PEM or base64 data to PEM blocks. PEM data can
+// contain multiple blocks; other text around them is ignored. DER and base64
+// data is a single object and its block type is detected with DERType.
+func DecodeBlocks(data []byte) ([]*pem.Block, error) {
+	var der []byte
+	switch DetectFormat(data) {
+	case FormatPEM:
+		var blocks []*pem.Block
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			blocks = append(blocks, block)
+		}
+		if len(blocks) == 0 {
+			return nil, fmt.Errorf("%w: no PEM blocks", ErrInvalidFormat)
+		}
+		return blocks, nil
+	case FormatDER:
+		der = data
+	case FormatBase64:
+		der, _ = decodeBase64(data)
+	default:
+		return nil, fmt.Errorf("%w: not DER, PEM or base64", ErrInvalidFormat)
+	}
+	typ, err := DERType(der)
+	if err != nil {
+		return nil, err
+	}
+	return []*pem.Block{{Type: typ, Bytes: der}}, nil
+}
+
+// ParseBlock parses a PEM block. Returns *x509.Certificate,
+// *x509.CertificateRequest, *x509.RevocationList, a public key or a private
+// key depending on the block type.
+func ParseBlock(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case PEMCertificate:
+		return x509.ParseCertificate(block.Bytes)
+	case PEMCSR, "NEW CERTIFICATE REQUEST":
+		return x509.ParseCertificateRequest(block.Bytes)
+	case PEMCRL:
+		return x509.ParseRevocationList(block.Bytes)
+	case PEMPublicKey:
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case PEMRSAPublicKey:
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return parseKeyBlock(block)
+	}
+}
+
+// ParseObjects decodes data with DecodeBlocks and parses each block with
+// ParseBlock.
+func ParseObjects(data []byte) ([]interface{}, error) {
+	blocks, err := DecodeBlocks(data)
+	if err != nil {
+		return nil, err
+	}
+	objs := make([]interface{}, 0, len(blocks))
+	for _, b := range blocks {
+		o, err := ParseBlock(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.Type, err)
+		}
+		objs = append(objs, o)
+	}
+	return objs, nil
+}
+
+// ObjectToBlock returns the PEM block for an object returned by ParseBlock.
+// Public keys are stored as PKIX. RSA and EC private keys use the same block
+// types as KeyToPEM, other private keys are stored as PKCS#8.
+func ObjectToBlock(obj interface{}) (*pem.Block, error) {
+	switch o := obj.(type) {
+	case *x509.Certificate:
+		return &pem.Block{Type: PEMCertificate, Bytes: o.Raw}, nil
+	case *x509.CertificateRequest:
+		return &pem.Block{Type: PEMCSR, Bytes: o.Raw}, nil
+	case *x509.RevocationList:
+		return &pem.Block{Type: PEMCRL, Bytes: o.Raw}, nil
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: PEMRSAPrivateKey, Bytes: x509.MarshalPKCS1PrivateKey(o)}, nil
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(o)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: PEMECPrivateKey, Bytes: b}, nil
+	}
+	if b, err := x509.MarshalPKIXPublicKey(obj); err == nil {
+		return &pem.Block{Type: PEMPublicKey, Bytes: b}, nil
+	}
+	if b, err := x509.MarshalPKCS8PrivateKey(obj); err == nil {
+		return &pem.Block{Type: PEMPrivateKey, Bytes: b}, nil
+	}
+	return nil, &KeyTypeError{Param: "object", Key: obj}
+}
+
+// PublicKeyToPEM converts a public key to a PKIX "PUBLIC KEY" PEM block.
+func PublicKeyToPEM(pubKey interface{}) ([]byte, error) {
+	b, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMPublicKey, Bytes: b}), nil
+}
+
+// KeyToPKCS8PEM converts a private key to a PKCS#8 "PRIVATE KEY" PEM block.
+func KeyToPKCS8PEM(privKey interface{}) ([]byte, error) {
+	b, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMPrivateKey, Bytes: b}), nil
+}
+
+// Convert converts data from any format to the to format. PEM output contains
+// all blocks in data. DER and base64 output only support a single object.
+func Convert(data []byte, to Format) ([]byte, error) {
+	blocks, err := DecodeBlocks(data)
+	if err != nil {
+		return nil, err
+	}
+	if to == FormatPEM {
+		var b bytes.Buffer
+		for _, block := range blocks {
+			// Drop headers such as Proc-Type.
+			if err := pem.Encode(&b, &pem.Block{Type: block.Type, Bytes: block.Bytes}); err != nil {
+				return nil, err
+			}
+		}
+		return b.Bytes(), nil
+	}
+	if len(blocks) != 1 {
+		return nil, fmt.Errorf("%s output needs a single object, got %d", to, len(blocks))
+	}
+	switch to {
+	case FormatDER:
+		return blocks[0].Bytes, nil
+	case FormatBase64:
+		return []byte(base64.StdEncoding.EncodeToString(blocks[0].Bytes)), nil
+	default:
+		return nil, fmt.Errorf("invalid output format, got %d", to)
+	}
+}
+
+// ConvertFile converts the contents of inFile with Convert and stores the
+// result in outFile. outFile is not overwritten.
+func ConvertFile(inFile, outFile string, to Format) error {
+	data, err := filehelper.ReadFileByte(inFile)
+	if err != nil {
+		return err
+	}
+	out, err := Convert(data, to)
+	if err != nil {
+		return err
+	}
+	return writeFile(out, outFile, false)
+}
+
+// decodeBase64 decodes standard or URL-safe base64 with or without padding.
+// Whitespace is ignored.
+func decodeBase64(data []byte) ([]byte, error) {
+	s := string(bytes.Join(bytes.Fields(data), nil))
+	if s == "" {
+		return nil, fmt.Errorf("%w: empty base64 data", ErrInvalidFormat)
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding,
+		base64.URLEncoding, base64.RawURLEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: invalid base64 data", ErrInvalidFormat)
+}
@@ -0,0 +1,115 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestConvert(t *testing.T) {
+	caCert, caPrivKey, err := CustomECRootCA("root1", "org1", "1", "US", "P256",
+		1, 0, CAKeyUsageConstant|x509.KeyUsageCRLSign)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "csr1"}}, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating CSR: %s", err.Error())
+	}
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating CRL: %s", err.Error())
+	}
+	pubDER, _ := x509.MarshalPKIXPublicKey(caPrivKey.Public())
+	ecDER, _ := x509.MarshalECPrivateKey(caPrivKey)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+
+	tests := []struct {
+		der []byte
+		typ string
+	}{
+		{caCert.Raw, PEMCertificate},
+		{csrDER, PEMCSR},
+		{crlDER, PEMCRL},
+		{pubDER, PEMPublicKey},
+		{ecDER, PEMECPrivateKey},
+		{edDER, PEMPrivateKey},
+	}
+	var stream []byte
+	for _, tt := range tests {
+		if f := DetectFormat(tt.der); f != FormatDER {
+			t.Errorf("%s: DER detected as %s", tt.typ, f)
+		}
+		p, err := Convert(tt.der, FormatPEM)
+		if err != nil {
+			t.Errorf("%s: error converting to PEM: %s", tt.typ, err.Error())
+			continue
+		}
+		blocks, err := DecodeBlocks(p)
+		if err != nil || len(blocks) != 1 || blocks[0].Type != tt.typ {
+			t.Errorf("%s: bad PEM output: %s", tt.typ, p)
+			continue
+		}
+		b64, err := Convert(p, FormatBase64)
+		if err != nil {
+			t.Errorf("%s: error converting to base64: %s", tt.typ, err.Error())
+			continue
+		}
+		if f := DetectFormat(b64); f != FormatBase64 {
+			t.Errorf("%s: base64 detected as %s", tt.typ, f)
+		}
+		der, err := Convert(b64, FormatDER)
+		if err != nil || !bytes.Equal(der, tt.der) {
+			t.Errorf("%s: DER round trip failed: %v", tt.typ, err)
+		}
+		stream = append(stream, p...)
+	}
+
+	// A multi-block stream parses to all objects but can not become DER.
+	objs, err := ParseObjects(stream)
+	if err != nil {
+		t.Fatalf("ParseObjects error: %s", err.Error())
+	}
+	if len(objs) != len(tests) {
+		t.Fatalf("got %d objects, want %d", len(objs), len(tests))
+	}
+	for i, o := range objs {
+		block, err := ObjectToBlock(o)
+		if err != nil {
+			t.Errorf("ObjectToBlock error: %s", err.Error())
+			continue
+		}
+		if block.Type != tests[i].typ || !bytes.Equal(block.Bytes, tests[i].der) {
+			t.Errorf("ObjectToBlock(%T) did not round trip", o)
+		}
+	}
+	if _, err := Convert(stream, FormatDER); err == nil {
+		t.Errorf("converted multiple objects to DER")
+	}
+	if f := DetectFormat([]byte("not a cert!")); f != FormatUnknown {
+		t.Errorf("garbage detected as %s", f)
+	}
+
+	// Invalid input returns ErrInvalidFormat.
+	for _, data := range [][]byte{[]byte("not a cert!"), {0x30, 0x03, 0x02, 0x01, 0x01},
+		[]byte("-----BEGIN CERTIFICATE-----\nnot base64\n")} {
+		if _, err := Convert(data, FormatPEM); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("bad error for %q, got %v", data, err)
+		}
+	}
+	if _, err := decodeBase64([]byte(" ")); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("bad error for empty base64, got %v", err)
+	}
+}
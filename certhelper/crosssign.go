@@ -0,0 +1,160 @@
+package certhelper
+
+// Cross-signing and CA re-keying.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// CrossSign returns a certificate with the subject, public key and CA
+// properties of cert that is signed by caCert with caPrivKey. Clients that
+// trust caCert can use it as an intermediate to reach cert.
+// 	validity is in years. For example, 1.
+// 	caCert must be allowed to sign intermediates, i.e., its maxPathLen must
+// 	not be 0. Create roots that cross-sign with CrossSigningRSARootCA or
+// 	CrossSigningECRootCA.
+func CrossSign(cert, caCert *x509.Certificate, caPrivKey interface{},
+	serialNumber string, validity int) (*x509.Certificate, error) {
+
+	if cert.IsCA && caCert.MaxPathLen == 0 && caCert.MaxPathLenZero {
+		return nil, fmt.Errorf("caCert has a max path length of 0 and can not sign CAs")
+	}
+	tmpl, err := reissueTemplate(cert, serialNumber, validity)
+	if err != nil {
+		return nil, err
+	}
+	// Create certificate's DER bytes.
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, cert.PublicKey, caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	// Convert DER bytes to *x509.Certificate.
+	return x509.ParseCertificate(certDER)
+}
+
+// CrossSigningRSARootCA returns a self-signed x509 root CA with an RSA key that
+// can cross-sign other roots. It is RSARootCA with a max path length of
+// CrossSignMaxPathLenConstant.
+func CrossSigningRSARootCA(commonName, orgUnit, serialNumber, countryCode string,
+	keySize int) (*x509.Certificate, *rsa.PrivateKey, error) {
+
+	return CustomRSARootCA(commonName, orgUnit, serialNumber, countryCode,
+		keySize, CertValidityConstant, CrossSignMaxPathLenConstant, CAKeyUsageConstant)
+}
+
+// CrossSigningECRootCA returns a self-signed x509 root CA with an EC key that
+// can cross-sign other roots. It is ECRootCA with a max path length of
+// CrossSignMaxPathLenConstant.
+func CrossSigningECRootCA(commonName, orgUnit, serialNumber, countryCode string,
+	curve string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+
+	return CustomECRootCA(commonName, orgUnit, serialNumber, countryCode, curve,
+		CertValidityConstant, CrossSignMaxPathLenConstant, CAKeyUsageConstant)
+}
+
+// RekeyCA returns a new self-signed CA certificate with the subject and CA
+// properties of caCert and the public key of newPrivKey. The new certificate
+// is signed with newPrivKey.
+// 	validity is in years. For example, 1.
+// Cross-sign the result with the old CA to keep both trust anchors working.
+func RekeyCA(caCert *x509.Certificate, newPrivKey interface{},
+	serialNumber string, validity int) (*x509.Certificate, error) {
+
+	signer, ok := newPrivKey.(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: "newPrivKey", Key: newPrivKey}
+	}
+	tmpl, err := reissueTemplate(caCert, serialNumber, validity)
+	if err != nil {
+		return nil, err
+	}
+	// The subject key identifier belongs to the old key, Go generates a new one.
+	tmpl.SubjectKeyId = nil
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, signer.Public(), signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDER)
+}
+
+// RekeyRSARootCA returns a re-keyed caCert with a new RSA key. See RekeyCA.
+func RekeyRSARootCA(caCert *x509.Certificate, keySize int, serialNumber string,
+	validity int) (*x509.Certificate, *rsa.PrivateKey, error) {
+
+	privKey, err := rsaKey(keySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := RekeyCA(caCert, privKey, serialNumber, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, privKey, nil
+}
+
+// RekeyECRootCA returns a re-keyed caCert with a new EC key. See RekeyCA.
+func RekeyECRootCA(caCert *x509.Certificate, curve string, serialNumber string,
+	validity int) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+
+	privKey, err := sourceECKey(curve)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := RekeyCA(caCert, privKey, serialNumber, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, privKey, nil
+}
+
+// VerifyChain verifies cert against roots with intermediates and returns the
+// chains that were built.
+func VerifyChain(cert *x509.Certificate, intermediates,
+	roots []*x509.Certificate) ([][]*x509.Certificate, error) {
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range roots {
+		opts.Roots.AddCert(c)
+	}
+	for _, c := range intermediates {
+		opts.Intermediates.AddCert(c)
+	}
+	return cert.Verify(opts)
+}
+
+// reissueTemplate returns a template with the subject and CA properties of
+// cert.
+func reissueTemplate(cert *x509.Certificate, serialNumber string,
+	validity int) (*x509.Certificate, error) {
+
+	// Convert serial number to big int.
+	sn, err := parseSerial(serialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: sn,
+		// Use the raw subject so it matches the original byte for byte.
+		RawSubject:            cert.RawSubject,
+		Subject:               cert.Subject,
+		NotBefore:             time.Now().UTC(),
+		NotAfter:              time.Now().UTC().AddDate(validity, 0, 0),
+		IsCA:                  cert.IsCA,
+		BasicConstraintsValid: cert.BasicConstraintsValid,
+		MaxPathLen:            cert.MaxPathLen,
+		MaxPathLenZero:        cert.MaxPathLenZero,
+		KeyUsage:              cert.KeyUsage,
+		ExtKeyUsage:           cert.ExtKeyUsage,
+		SubjectKeyId:          cert.SubjectKeyId,
+	}, nil
+}
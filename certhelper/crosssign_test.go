@@ -0,0 +1,77 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestCrossSign(t *testing.T) {
+	// The old root must be able to sign an intermediate.
+	oldRoot, oldKey, err := CrossSigningECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	newRoot, newKey, err := RekeyECRootCA(oldRoot, "P384", "2", 1)
+	if err != nil {
+		t.Fatalf("error in RekeyECRootCA: %s", err.Error())
+	}
+	if !bytes.Equal(newRoot.RawSubject, oldRoot.RawSubject) {
+		t.Errorf("RekeyECRootCA did not preserve the subject")
+	}
+	if bytes.Equal(newRoot.SubjectKeyId, oldRoot.SubjectKeyId) {
+		t.Errorf("RekeyECRootCA reused the old subject key identifier")
+	}
+
+	// Cross-sign both ways.
+	newByOld, err := CrossSign(newRoot, oldRoot, oldKey, "3", 1)
+	if err != nil {
+		t.Fatalf("error in CrossSign: %s", err.Error())
+	}
+	oldByNew, err := CrossSign(oldRoot, newRoot, newKey, "4", 1)
+	if err != nil {
+		t.Fatalf("error in CrossSign: %s", err.Error())
+	}
+
+	// Default roots can not sign intermediates.
+	defaultRoot, defaultKey, err := RSARootCA("root2", "org1", "7", "US", 2048)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	if _, err := CrossSign(newRoot, defaultRoot, defaultKey, "8", 1); err == nil {
+		t.Errorf("CrossSign accepted a root with a max path length of 0")
+	}
+
+	leafNew, _, err := ECLeafCert("leaf1", "org1", "5", "US", "P256", newRoot, newKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	leafOld, _, err := ECLeafCert("leaf2", "org1", "6", "US", "P256", oldRoot, oldKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+
+	tests := []struct {
+		name          string
+		leaf          *x509.Certificate
+		intermediates []*x509.Certificate
+		root          *x509.Certificate
+		wantLen       int
+	}{
+		{"new-leaf-new-root", leafNew, nil, newRoot, 2},
+		{"new-leaf-old-root", leafNew, []*x509.Certificate{newByOld}, oldRoot, 3},
+		{"old-leaf-old-root", leafOld, nil, oldRoot, 2},
+		{"old-leaf-new-root", leafOld, []*x509.Certificate{oldByNew}, newRoot, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chains, err := VerifyChain(tt.leaf, tt.intermediates, []*x509.Certificate{tt.root})
+			if err != nil {
+				t.Fatalf("VerifyChain error: %s", err.Error())
+			}
+			if len(chains[0]) != tt.wantLen {
+				t.Errorf("got chain length %d, want %d", len(chains[0]), tt.wantLen)
+			}
+		})
+	}
+}
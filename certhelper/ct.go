@@ -0,0 +1,305 @@
+package certhelper
+
+// Certificate Transparency (RFC 6962) helpers for testing CT enforcement.
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+var (
+	// OIDCTPoison is the precertificate poison extension.
+	OIDCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+	// OIDCTSCTList is the embedded SCT list extension.
+	OIDCTSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// SCT is a v1 signed certificate timestamp.
+type SCT struct {
+	LogID      [32]byte
+	Timestamp  uint64 // Milliseconds since the epoch.
+	Extensions []byte
+	// Signature is a TLS DigitallySigned struct: hash algorithm, signature
+	// algorithm and the signature.
+	HashAlgorithm      uint8
+	SignatureAlgorithm uint8
+	Signature          []byte
+}
+
+// CTLog is a local stand-in for a CT log. It only signs SCTs and does not
+// keep a Merkle tree.
+type CTLog struct {
+	// LogID is the SHA-256 hash of the log's public key.
+	LogID [32]byte
+	key   *ecdsa.PrivateKey
+}
+
+// NewCTLog returns a CTLog with a new P-256 key.
+func NewCTLog() (*CTLog, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	spki, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &CTLog{LogID: sha256.Sum256(spki), key: key}, nil
+}
+
+// PublicKey returns the log's public key.
+func (l *CTLog) PublicKey() *ecdsa.PublicKey {
+	return &l.key.PublicKey
+}
+
+// AddPreChain returns an SCT for precert issued by issuer.
+func (l *CTLog) AddPreChain(precert, issuer *x509.Certificate) (*SCT, error) {
+	if _, ok := CertExtension(precert, OIDCTPoison); !ok {
+		return nil, fmt.Errorf("certificate does not have the poison extension")
+	}
+	sct := SCT{
+		LogID:              l.LogID,
+		Timestamp:          uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		HashAlgorithm:      4, // sha256
+		SignatureAlgorithm: 3, // ecdsa
+	}
+	input, err := sctSignatureInput(&sct, precert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(input)
+	sct.Signature, err = ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return &sct, nil
+}
+
+// VerifySCT verifies sct for cert issued by issuer. cert can be the
+// precertificate or the final certificate with embedded SCTs.
+func (l *CTLog) VerifySCT(sct *SCT, cert, issuer *x509.Certificate) error {
+	if sct.LogID != l.LogID {
+		return fmt.Errorf("SCT is from a different log")
+	}
+	input, err := sctSignatureInput(sct, cert, issuer)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(input)
+	if !ecdsa.VerifyASN1(&l.key.PublicKey, digest[:], sct.Signature) {
+		return fmt.Errorf("invalid SCT signature")
+	}
+	return nil
+}
+
+// CreatePrecert returns a precertificate for tmpl signed by caCert with
+// caPrivKey. tmpl is not modified.
+func CreatePrecert(tmpl, caCert *x509.Certificate, pubKey,
+	caPrivKey interface{}) (*x509.Certificate, error) {
+
+	poison := pkix.Extension{Id: OIDCTPoison, Critical: true, Value: asn1.NullBytes}
+	return createWithExtension(tmpl, caCert, pubKey, caPrivKey, poison)
+}
+
+// CreateCertWithSCTs returns the final certificate for tmpl with scts embedded.
+// tmpl, caCert, pubKey and caPrivKey must be the same as the ones passed to
+// CreatePrecert.
+func CreateCertWithSCTs(tmpl, caCert *x509.Certificate, pubKey,
+	caPrivKey interface{}, scts []*SCT) (*x509.Certificate, error) {
+
+	// SignedCertificateTimestampList is a list of length-prefixed SCTs.
+	var list []byte
+	for _, s := range scts {
+		list = appendUint16Prefixed(list, s.Marshal())
+	}
+	value, err := asn1.Marshal(appendUint16Prefixed(nil, list))
+	if err != nil {
+		return nil, err
+	}
+	ext := pkix.Extension{Id: OIDCTSCTList, Value: value}
+	return createWithExtension(tmpl, caCert, pubKey, caPrivKey, ext)
+}
+
+// CertSCTs returns the SCTs embedded in cert.
+func CertSCTs(cert *x509.Certificate) ([]*SCT, error) {
+	var scts []*SCT
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(OIDCTSCTList) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil {
+			return nil, err
+		}
+		list, rest, err := readUint16Prefixed(list)
+		if err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("invalid SCT list")
+		}
+		for len(list) > 0 {
+			var b []byte
+			b, list, err = readUint16Prefixed(list)
+			if err != nil {
+				return nil, fmt.Errorf("invalid SCT list")
+			}
+			s, err := ParseSCT(b)
+			if err != nil {
+				return nil, err
+			}
+			scts = append(scts, s)
+		}
+	}
+	return scts, nil
+}
+
+// Marshal returns the TLS encoding of the SCT.
+func (s *SCT) Marshal() []byte {
+	var b bytes.Buffer
+	b.WriteByte(0) // v1
+	b.Write(s.LogID[:])
+	binary.Write(&b, binary.BigEndian, s.Timestamp)
+	b.Write(appendUint16Prefixed(nil, s.Extensions))
+	b.WriteByte(s.HashAlgorithm)
+	b.WriteByte(s.SignatureAlgorithm)
+	b.Write(appendUint16Prefixed(nil, s.Signature))
+	return b.Bytes()
+}
+
+// ParseSCT parses a TLS encoded SCT.
+func ParseSCT(b []byte) (*SCT, error) {
+	var s SCT
+	if len(b) < 1+32+8 || b[0] != 0 {
+		return nil, fmt.Errorf("invalid SCT")
+	}
+	copy(s.LogID[:], b[1:33])
+	s.Timestamp = binary.BigEndian.Uint64(b[33:41])
+	ext, rest, err := readUint16Prefixed(b[41:])
+	if err != nil || len(rest) < 2 {
+		return nil, fmt.Errorf("invalid SCT")
+	}
+	s.Extensions = ext
+	s.HashAlgorithm, s.SignatureAlgorithm = rest[0], rest[1]
+	sig, rest, err := readUint16Prefixed(rest[2:])
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("invalid SCT")
+	}
+	s.Signature = sig
+	return &s, nil
+}
+
+// sctSignatureInput returns the data signed by the log for a precert entry.
+func sctSignatureInput(sct *SCT, cert, issuer *x509.Certificate) ([]byte, error) {
+	tbs, err := removeTBSExtensions(cert.RawTBSCertificate, OIDCTPoison, OIDCTSCTList)
+	if err != nil {
+		return nil, err
+	}
+	if len(tbs) >= 1<<24 {
+		return nil, fmt.Errorf("TBSCertificate is too large")
+	}
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+
+	var b bytes.Buffer
+	b.WriteByte(0) // v1
+	b.WriteByte(0) // certificate_timestamp
+	binary.Write(&b, binary.BigEndian, sct.Timestamp)
+	b.Write([]byte{0, 1}) // precert_entry
+	b.Write(issuerKeyHash[:])
+	b.Write([]byte{byte(len(tbs) >> 16), byte(len(tbs) >> 8), byte(len(tbs))})
+	b.Write(tbs)
+	b.Write(appendUint16Prefixed(nil, sct.Extensions))
+	return b.Bytes(), nil
+}
+
+// removeTBSExtensions returns the DER TBSCertificate without the extensions
+// in oids.
+func removeTBSExtensions(rawTBS []byte, oids ...asn1.ObjectIdentifier) ([]byte, error) {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(rawTBS, &tbs); err != nil {
+		return nil, err
+	}
+	var out []byte
+	rest := tbs.Bytes
+	for len(rest) > 0 {
+		var field asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &field)
+		if err != nil {
+			return nil, err
+		}
+		// Extensions are in [3] EXPLICIT.
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			out = append(out, field.FullBytes...)
+			continue
+		}
+		var exts []pkix.Extension
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			return nil, err
+		}
+		var kept []pkix.Extension
+	next:
+		for _, e := range exts {
+			for _, oid := range oids {
+				if e.Id.Equal(oid) {
+					continue next
+				}
+			}
+			kept = append(kept, e)
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		b, err := asn1.Marshal(kept)
+		if err != nil {
+			return nil, err
+		}
+		b, err = asn1.Marshal(asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: b})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: out})
+}
+
+// createWithExtension signs a copy of tmpl with ext added to its
+// ExtraExtensions.
+func createWithExtension(tmpl, caCert *x509.Certificate, pubKey,
+	caPrivKey interface{}, ext pkix.Extension) (*x509.Certificate, error) {
+
+	t := *tmpl
+	t.ExtraExtensions = append([]pkix.Extension{}, tmpl.ExtraExtensions...)
+	addExtension(&t, ext)
+	certDER, err := x509.CreateCertificate(rand.Reader, &t, caCert, pubKey, caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDER)
+}
+
+// appendUint16Prefixed appends b to dst with a 2-byte length prefix.
+func appendUint16Prefixed(dst, b []byte) []byte {
+	dst = append(dst, byte(len(b)>>8), byte(len(b)))
+	return append(dst, b...)
+}
+
+// readUint16Prefixed reads a 2-byte length-prefixed value from b.
+func readUint16Prefixed(b []byte) (value, rest []byte, err error) {
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("short buffer")
+	}
+	n := int(b[0])<<8 | int(b[1])
+	if len(b) < 2+n {
+		return nil, nil, fmt.Errorf("short buffer")
+	}
+	return b[2 : 2+n], b[2+n:], nil
+}
@@ -0,0 +1,69 @@
+package certhelper
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestCreateCertWithSCTs(t *testing.T) {
+	caCert, caPrivKey, err := CustomECRootCA("root1", "org1", "1234", "US",
+		"P256", 1, 0, CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	key, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error creating leaf key: %s", err.Error())
+	}
+	tmpl, err := LeafTemplate("leaf1", "org1", "2", "US", "EC")
+	if err != nil {
+		t.Fatalf("error creating leaf template: %s", err.Error())
+	}
+	ctLog, err := NewCTLog()
+	if err != nil {
+		t.Fatalf("error creating CT log: %s", err.Error())
+	}
+
+	precert, err := CreatePrecert(tmpl, caCert, key.Public(), caPrivKey)
+	if err != nil {
+		t.Fatalf("error in CreatePrecert: %s", err.Error())
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	opts := x509.VerifyOptions{Roots: roots}
+	// The poison extension is critical so precertificates must not verify.
+	if _, err := precert.Verify(opts); err == nil {
+		t.Errorf("precertificate verified")
+	}
+
+	sct, err := ctLog.AddPreChain(precert, caCert)
+	if err != nil {
+		t.Fatalf("error in AddPreChain: %s", err.Error())
+	}
+	cert, err := CreateCertWithSCTs(tmpl, caCert, key.Public(), caPrivKey, []*SCT{sct})
+	if err != nil {
+		t.Fatalf("error in CreateCertWithSCTs: %s", err.Error())
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		t.Errorf("final certificate did not verify: %s", err.Error())
+	}
+
+	scts, err := CertSCTs(cert)
+	if err != nil {
+		t.Fatalf("error in CertSCTs: %s", err.Error())
+	}
+	if len(scts) != 1 {
+		t.Fatalf("got %d SCTs, want 1", len(scts))
+	}
+	if err := ctLog.VerifySCT(scts[0], cert, caCert); err != nil {
+		t.Errorf("VerifySCT error: %s", err.Error())
+	}
+	// The SCT must not verify for a different certificate.
+	other, err := CreateCertWithSCTs(tmpl, caCert, caPrivKey.Public(), caPrivKey, scts)
+	if err != nil {
+		t.Fatalf("error in CreateCertWithSCTs: %s", err.Error())
+	}
+	if err := ctLog.VerifySCT(scts[0], other, caCert); err == nil {
+		t.Errorf("VerifySCT accepted an SCT for a different certificate")
+	}
+}
@@ -0,0 +1,118 @@
+package certhelper
+
+// EC curve selection.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Curve is an EC curve supported by Go.
+type Curve int
+
+// Supported curves.
+const (
+	CurveP224 Curve = iota + 1
+	CurveP256
+	CurveP384
+	CurveP521
+)
+
+// CurvePolicy is called before an EC key is generated. Return an error to
+// forbid a curve. nil allows all curves. For example, set it to ForbidP224.
+var CurvePolicy func(Curve) error
+
+// curveNames maps lowercase names and aliases to curves.
+var curveNames = map[string]Curve{
+	"p224":       CurveP224,
+	"p-224":      CurveP224,
+	"secp224r1":  CurveP224,
+	"p256":       CurveP256,
+	"p-256":      CurveP256,
+	"prime256v1": CurveP256,
+	"secp256r1":  CurveP256,
+	"p384":       CurveP384,
+	"p-384":      CurveP384,
+	"secp384r1":  CurveP384,
+	"p521":       CurveP521,
+	"p-521":      CurveP521,
+	"secp521r1":  CurveP521,
+}
+
+// ParseCurve returns the curve for name (case-insensitive). Valid names are
+// P224, P256, P384 and P521 with or without the dash, and the OpenSSL
+// aliases secp224r1, prime256v1, secp256r1, secp384r1 and secp521r1.
+func ParseCurve(name string) (Curve, error) {
+	c, ok := curveNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w, got %q", ErrUnknownCurve, name)
+	}
+	return c, nil
+}
+
+// String returns the name of the curve, e.g. "P256".
+func (c Curve) String() string {
+	switch c {
+	case CurveP224:
+		return "P224"
+	case CurveP256:
+		return "P256"
+	case CurveP384:
+		return "P384"
+	case CurveP521:
+		return "P521"
+	default:
+		return fmt.Sprintf("Curve(%d)", int(c))
+	}
+}
+
+// Elliptic returns the elliptic.Curve for c or nil if c is invalid.
+func (c Curve) Elliptic() elliptic.Curve {
+	switch c {
+	case CurveP224:
+		return elliptic.P224()
+	case CurveP256:
+		return elliptic.P256()
+	case CurveP384:
+		return elliptic.P384()
+	case CurveP521:
+		return elliptic.P521()
+	default:
+		return nil
+	}
+}
+
+// ForbidP224 is a CurvePolicy that rejects P-224.
+func ForbidP224(c Curve) error {
+	if c == CurveP224 {
+		return fmt.Errorf("%w: P224", ErrForbiddenCurve)
+	}
+	return nil
+}
+
+// GenerateECKey returns an EC key pair on curve after checking CurvePolicy.
+func GenerateECKey(curve Curve) (*ecdsa.PrivateKey, error) {
+	ec := curve.Elliptic()
+	if ec == nil {
+		return nil, fmt.Errorf("%w, got %s", ErrUnknownCurve, curve)
+	}
+	if CurvePolicy != nil {
+		if err := CurvePolicy(curve); err != nil {
+			return nil, err
+		}
+	}
+	return ecdsa.GenerateKey(ec, rand.Reader)
+}
+
+// ecKey parses name with ParseCurve and generates a key with GenerateECKey.
+// The string based functions use it.
+func ecKey(name string) (*ecdsa.PrivateKey, error) {
+	curve, err := ParseCurve(name)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateECKey(curve)
+}
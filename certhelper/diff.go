@@ -0,0 +1,357 @@
+package certhelper
+
+// Certificate and CSR comparison.
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Difference is a field that differs between two certificates or CSRs.
+type Difference struct {
+	// Field is the name of the field, e.g. "Subject" or "Extension 1.2.3".
+	Field string
+	// A and B are readable values from the first and second object. Empty
+	// means the field is not set.
+	A, B string
+}
+
+// Differences is the result of Diff.
+type Differences []Difference
+
+// String returns a readable report with one block per difference:
+// 	Subject:
+// 	  - CN=old
+// 	  + CN=new
+func (d Differences) String() string {
+	var b strings.Builder
+	for _, diff := range d {
+		fmt.Fprintf(&b, "%s:\n  - %s\n  + %s\n", diff.Field, noneIfEmpty(diff.A),
+			noneIfEmpty(diff.B))
+	}
+	return b.String()
+}
+
+// Filter returns the differences in fields. Use it to ignore fields that are
+// expected to change, e.g., the serial number and validity of a reissued
+// certificate.
+func (d Differences) Filter(fields ...string) Differences {
+	var out Differences
+	for _, diff := range d {
+		for _, f := range fields {
+			if diff.Field == f {
+				out = append(out, diff)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Diff compares a and b field by field and returns the differences in order.
+// a and b can be *x509.Certificate or *x509.CertificateRequest. Compared
+// fields are:
+// 	Version, SerialNumber, Subject, Issuer, NotBefore, NotAfter,
+// 	SignatureAlgorithm, PublicKey, KeyUsage, ExtKeyUsage, BasicConstraints,
+// 	DNSNames, IPAddresses, EmailAddresses, URIs, SubjectKeyId, AuthorityKeyId
+// and "Extension <OID>" for extensions that do not have a field. CSRs only have
+// Subject, SignatureAlgorithm, PublicKey, the SANs and extensions.
+// Comparing a certificate with a CSR checks if the certificate has the
+// subject, public key, SANs and requested extensions of the CSR.
+func Diff(a, b interface{}) (Differences, error) {
+	fa, err := diffFields(a)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := diffFields(b)
+	if err != nil {
+		return nil, err
+	}
+	csrA, csrB := isCSR(a), isCSR(b)
+
+	var diffs Differences
+	add := func(name, va, vb string) {
+		if va != vb {
+			diffs = append(diffs, Difference{Field: name, A: va, B: vb})
+		}
+	}
+	// Certificate and CSR, only check the fields in the CSR.
+	if csrA != csrB {
+		var csrFields []diffField
+		var cert *x509.Certificate
+		if csrA {
+			csrFields, cert = fa, b.(*x509.Certificate)
+		} else {
+			csrFields, cert = fb, a.(*x509.Certificate)
+		}
+		certValues := fieldMap(diffFieldsCert(cert))
+		for _, f := range csrFields {
+			if f.name == "SignatureAlgorithm" {
+				continue
+			}
+			certValue := certValues[f.name]
+			if strings.HasPrefix(f.name, "Extension ") {
+				certValue = ""
+				if ext, ok := findExtension(cert.Extensions, f.name[len("Extension "):]); ok {
+					certValue = extensionValue(ext)
+				}
+			}
+			if csrA {
+				add(f.name, f.value, certValue)
+			} else {
+				add(f.name, certValue, f.value)
+			}
+		}
+		return diffs, nil
+	}
+
+	mb := fieldMap(fb)
+	seen := make(map[string]bool)
+	for _, f := range fa {
+		seen[f.name] = true
+		add(f.name, f.value, mb[f.name])
+	}
+	for _, f := range fb {
+		if !seen[f.name] {
+			add(f.name, "", f.value)
+		}
+	}
+	return diffs, nil
+}
+
+type diffField struct {
+	name, value string
+}
+
+// Extensions with their own field in Diff.
+var diffedExtensions = map[string]bool{
+	"2.5.29.14": true, // Subject key identifier.
+	"2.5.29.15": true, // Key usage.
+	"2.5.29.17": true, // Subject alternative name.
+	"2.5.29.19": true, // Basic constraints.
+	"2.5.29.35": true, // Authority key identifier.
+	"2.5.29.37": true, // Extended key usage.
+}
+
+// diffFields returns the fields of a certificate or CSR in order.
+func diffFields(obj interface{}) ([]diffField, error) {
+	switch o := obj.(type) {
+	case *x509.Certificate:
+		if o == nil {
+			return nil, errors.New("certificate is nil")
+		}
+		return diffFieldsCert(o), nil
+	case *x509.CertificateRequest:
+		if o == nil {
+			return nil, errors.New("CSR is nil")
+		}
+		fields := []diffField{
+			{"Subject", o.Subject.String()},
+			{"SignatureAlgorithm", sigAlgString(o.SignatureAlgorithm)},
+			{"PublicKey", describePublicKey(o.PublicKey)},
+		}
+		fields = append(fields, sanFields(o.DNSNames, o.IPAddresses, o.EmailAddresses, o.URIs)...)
+		return append(fields, extensionFields(o.Extensions, false)...), nil
+	default:
+		return nil, &KeyTypeError{Param: "certificate or CSR", Key: obj}
+	}
+}
+
+func diffFieldsCert(c *x509.Certificate) []diffField {
+	serial := ""
+	if c.SerialNumber != nil {
+		serial = c.SerialNumber.String()
+	}
+	fields := []diffField{
+		{"Version", strconv.Itoa(c.Version)},
+		{"SerialNumber", serial},
+		{"Subject", c.Subject.String()},
+		{"Issuer", c.Issuer.String()},
+		{"NotBefore", diffTime(c.NotBefore)},
+		{"NotAfter", diffTime(c.NotAfter)},
+		{"SignatureAlgorithm", sigAlgString(c.SignatureAlgorithm)},
+		{"PublicKey", describePublicKey(c.PublicKey)},
+		{"KeyUsage", keyUsageString(c.KeyUsage)},
+		{"ExtKeyUsage", extKeyUsageString(c.ExtKeyUsage, c.UnknownExtKeyUsage)},
+		{"BasicConstraints", basicConstraintsString(c)},
+	}
+	fields = append(fields, sanFields(c.DNSNames, c.IPAddresses, c.EmailAddresses, c.URIs)...)
+	fields = append(fields,
+		diffField{"SubjectKeyId", colonHex(c.SubjectKeyId)},
+		diffField{"AuthorityKeyId", colonHex(c.AuthorityKeyId)},
+	)
+	return append(fields, extensionFields(c.Extensions, true)...)
+}
+
+func sanFields(dnsNames []string, ips []net.IP, emails []string, uris []*url.URL) []diffField {
+	var ipStrings, uriStrings []string
+	for _, ip := range ips {
+		ipStrings = append(ipStrings, ip.String())
+	}
+	for _, u := range uris {
+		uriStrings = append(uriStrings, u.String())
+	}
+	return []diffField{
+		{"DNSNames", strings.Join(dnsNames, ", ")},
+		{"IPAddresses", strings.Join(ipStrings, ", ")},
+		{"EmailAddresses", strings.Join(emails, ", ")},
+		{"URIs", strings.Join(uriStrings, ", ")},
+	}
+}
+
+// extensionFields returns "Extension <OID>" fields sorted by OID. For
+// certificates, extensions with their own field are skipped. For CSRs only
+// the SANs are parsed by crypto/x509.
+func extensionFields(exts []pkix.Extension, cert bool) []diffField {
+	var fields []diffField
+	for _, ext := range exts {
+		oid := ext.Id.String()
+		if (cert && diffedExtensions[oid]) || (!cert && oid == "2.5.29.17") {
+			continue
+		}
+		fields = append(fields, diffField{"Extension " + oid, extensionValue(ext)})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].name < fields[j].name })
+	return fields
+}
+
+func findExtension(exts []pkix.Extension, oid string) (pkix.Extension, bool) {
+	for _, ext := range exts {
+		if ext.Id.String() == oid {
+			return ext, true
+		}
+	}
+	return pkix.Extension{}, false
+}
+
+func extensionValue(ext pkix.Extension) string {
+	v := hex.EncodeToString(ext.Value)
+	if ext.Critical {
+		v += " (critical)"
+	}
+	return v
+}
+
+func fieldMap(fields []diffField) map[string]string {
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.name] = f.value
+	}
+	return m
+}
+
+func isCSR(obj interface{}) bool {
+	_, ok := obj.(*x509.CertificateRequest)
+	return ok
+}
+
+func diffTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func sigAlgString(algo x509.SignatureAlgorithm) string {
+	if algo == x509.UnknownSignatureAlgorithm {
+		return ""
+	}
+	return algo.String()
+}
+
+// describePublicKey returns the key type, size and SPKI pin of pubKey.
+func describePublicKey(pubKey interface{}) string {
+	var desc string
+	switch k := pubKey.(type) {
+	case nil:
+		return ""
+	case *rsa.PublicKey:
+		desc = fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		desc = "ECDSA " + k.Params().Name
+	case ed25519.PublicKey:
+		desc = "Ed25519"
+	default:
+		desc = fmt.Sprintf("%T", pubKey)
+	}
+	if pin, err := SPKIPin(pubKey); err == nil {
+		desc += " " + pin
+	}
+	return desc
+}
+
+// RFC 5280 names of the key usage bits in order.
+var keyUsageNames = []string{"digitalSignature", "contentCommitment",
+	"keyEncipherment", "dataEncipherment", "keyAgreement", "keyCertSign",
+	"cRLSign", "encipherOnly", "decipherOnly"}
+
+func keyUsageString(ku x509.KeyUsage) string {
+	var names []string
+	for i, name := range keyUsageNames {
+		if ku&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:  "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:     "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:       "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+func extKeyUsageString(usages []x509.ExtKeyUsage, unknown []asn1.ObjectIdentifier) string {
+	var names []string
+	for _, u := range usages {
+		if name, ok := extKeyUsageNames[u]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("ExtKeyUsage(%d)", u))
+		}
+	}
+	for _, oid := range unknown {
+		names = append(names, oid.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+func basicConstraintsString(c *x509.Certificate) string {
+	if !c.BasicConstraintsValid {
+		return ""
+	}
+	if !c.IsCA {
+		return "CA:FALSE"
+	}
+	if c.MaxPathLen > 0 || (c.MaxPathLen == 0 && c.MaxPathLenZero) {
+		return fmt.Sprintf("CA:TRUE, pathlen:%d", c.MaxPathLen)
+	}
+	return "CA:TRUE"
+}
+
+func noneIfEmpty(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
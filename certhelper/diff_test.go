@@ -0,0 +1,124 @@
+package certhelper
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	cert1, _, err := CustomECLeafCert("leaf1", "org1", "2", "US", "P256", 1,
+		caCert, caPrivKey, WithDNSNames("a.example.com"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	cert2, _, err := CustomECLeafCert("leaf2", "org1", "3", "US", "P256", 1,
+		caCert, caPrivKey, WithDNSNames("b.example.com"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+
+	if diffs, err := Diff(cert1, cert1); err != nil || len(diffs) != 0 {
+		t.Errorf("certificate differs from itself: %v\n%s", err, diffs)
+	}
+	diffs, err := Diff(cert1, cert2)
+	if err != nil {
+		t.Fatalf("Diff error: %s", err.Error())
+	}
+	got := make(map[string]Difference)
+	for _, d := range diffs {
+		got[d.Field] = d
+	}
+	for _, f := range []string{"SerialNumber", "Subject", "PublicKey", "DNSNames"} {
+		if _, ok := got[f]; !ok {
+			t.Errorf("%s is not in the differences", f)
+		}
+	}
+	for _, f := range []string{"Issuer", "SignatureAlgorithm", "KeyUsage", "AuthorityKeyId"} {
+		if d, ok := got[f]; ok {
+			t.Errorf("%s should not differ, got %q and %q", f, d.A, d.B)
+		}
+	}
+	if d := got["DNSNames"]; d.A != "a.example.com" || d.B != "b.example.com" {
+		t.Errorf("bad DNSNames difference, got %q and %q", d.A, d.B)
+	}
+	report := diffs.Filter("DNSNames").String()
+	if report != "DNSNames:\n  - a.example.com\n  + b.example.com\n" {
+		t.Errorf("bad report, got %q", report)
+	}
+	if !strings.Contains(diffs.String(), "Subject:\n") {
+		t.Errorf("report does not contain the subject, got\n%s", diffs)
+	}
+
+	if _, err := Diff(cert1, "cert"); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("Diff with a string, got %v", err)
+	}
+	var nilCert *x509.Certificate
+	if _, err := Diff(cert1, nilCert); err == nil {
+		t.Errorf("Diff with a nil certificate did not return an error")
+	}
+	var nilCSR *x509.CertificateRequest
+	if _, err := Diff(nilCSR, cert1); err == nil {
+		t.Errorf("Diff with a nil CSR did not return an error")
+	}
+}
+
+func TestDiffCSR(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	key, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         "leaf1",
+			Country:            []string{"US"},
+			Organization:       []string{"org1"},
+			OrganizationalUnit: []string{"org1"},
+			SerialNumber:       "2",
+		},
+		DNSNames: []string{"leaf1.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatalf("error creating CSR: %s", err.Error())
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatalf("error parsing CSR: %s", err.Error())
+	}
+	cert, err := CustomLeafCertWithKey("leaf1", "org1", "2", "US", 1, key, caCert,
+		caPrivKey, WithDNSNames("leaf1.example.com"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	if diffs, err := Diff(csr, cert); err != nil || len(diffs) != 0 {
+		t.Errorf("certificate does not match CSR: %v\n%s", err, diffs)
+	}
+
+	// Same certificate with another key and name.
+	other, _, err := CustomECLeafCert("leaf1", "org1", "2", "US", "P256", 1,
+		caCert, caPrivKey, WithDNSNames("other.example.com"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	diffs, err := Diff(other, csr)
+	if err != nil {
+		t.Fatalf("Diff error: %s", err.Error())
+	}
+	if len(diffs) != 2 || diffs[0].Field != "PublicKey" || diffs[1].Field != "DNSNames" {
+		t.Errorf("bad differences, got\n%s", diffs)
+	}
+	if diffs[1].A != "other.example.com" || diffs[1].B != "leaf1.example.com" {
+		t.Errorf("bad order of values, got %q and %q", diffs[1].A, diffs[1].B)
+	}
+}
@@ -4,12 +4,7 @@ package certhelper
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"fmt"
-	"strings"
 )
 
 // ECRootCA returns a self-signed x509 root CA with an EC key.
@@ -20,33 +15,23 @@ func ECRootCA(commonName, orgUnit, serialNumber, countryCode string,
 		CertValidityConstant, MaxPathLenConstant, CAKeyUsageConstant)
 }
 
-// CustomECRootCA returns a custom self-signed x509 root CA with an EC key.
+// CustomECRootCA returns a custom self-signed x509 root CA with an EC key. opts
+// are passed to CustomCATemplate.
 func CustomECRootCA(commonName, orgUnit, serialNumber, countryCode, curve string,
-	validity, maxPathLen int,
-	keyUsage x509.KeyUsage) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	validity, maxPathLen int, keyUsage x509.KeyUsage,
+	opts ...TemplateOption) (*x509.Certificate, *ecdsa.PrivateKey, error) {
 
-	// Generate EC keypair.
-	privKey, err := ECKeys(curve)
+	// Generate EC keypair or get one from ECKeySource.
+	privKey, err := sourceECKey(curve)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Get certificate template.
-	tmpl, err := CustomCATemplate(commonName, orgUnit, serialNumber, countryCode,
-		"EC", validity, maxPathLen, keyUsage)
+	cert, err := CustomRootCAWithKey(commonName, orgUnit, serialNumber, countryCode,
+		validity, maxPathLen, keyUsage, privKey, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Create certificate's DER bytes.
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, privKey.Public(), privKey)
-	if err != nil {
-		return nil, nil, err
-	}
-	// Convert DER bytes to *x509.Certificate.
-	certParsed, err := x509.ParseCertificate(certDER)
-	if err != nil {
-		return nil, nil, err
-	}
-	return certParsed, privKey, nil
+	return cert, privKey, nil
 }
 
 // ECLeafCert returns a leaf certificate with an EC key.
@@ -59,58 +44,29 @@ func ECLeafCert(commonName, orgUnit, serialNumber, countryCode, curve string,
 }
 
 // CustomECLeafCert returns a custom leaf certificate with an EC key. Certificate
-// signed by caCert with caPrivKey.
+// signed by caCert with caPrivKey. opts are passed to CustomLeafTemplate.
 func CustomECLeafCert(commonName, orgUnit, serialNumber, countryCode, curve string,
-	validity int, caCert *x509.Certificate,
-	caPrivKey interface{}) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	validity int, caCert *x509.Certificate, caPrivKey interface{},
+	opts ...TemplateOption) (*x509.Certificate, *ecdsa.PrivateKey, error) {
 
-	// Generate EC keypair.
-	privKey, err := ECKeys(curve)
+	// Generate EC keypair or get one from ECKeySource.
+	privKey, err := sourceECKey(curve)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Get certificate template.
-	tmpl, err := CustomLeafTemplate(commonName, orgUnit, serialNumber,
-		countryCode, "EC", validity, LeafKeyUsageConstant)
+	cert, err := CustomLeafCertWithKey(commonName, orgUnit, serialNumber, countryCode,
+		validity, privKey, caCert, caPrivKey, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Parse private key and generate the certificate.
-	var certDER []byte
-	switch k := caPrivKey.(type) {
-	case *rsa.PrivateKey:
-		certDER, err = x509.CreateCertificate(rand.Reader, tmpl, caCert, privKey.Public(), k)
-	case *ecdsa.PrivateKey:
-		certDER, err = x509.CreateCertificate(rand.Reader, tmpl, caCert, privKey.Public(), k)
-	default:
-		return nil, nil, fmt.Errorf("invalid caPrivKey, got %v", k)
-	}
-	if err != nil {
-		return nil, nil, err
-	}
-	// Convert DER bytes to *x509.Certificate.
-	certParsed, err := x509.ParseCertificate(certDER)
-	if err != nil {
-		return nil, nil, err
-	}
-	return certParsed, privKey, nil
+	return cert, privKey, nil
 }
 
-// ECKeys returns an EC key pair with a specified curve.
-// Valid curves are P224, P256, P384 and P521 (case-insensitive).
-// If curve is invalid or empty, P224 is used.
-// Go's supported curves: https://golang.org/pkg/crypto/elliptic/#Curve
+// ECKeys returns an EC key pair with a specified curve. See ParseCurve for
+// valid curve names. Unknown names return an error; P224 is no longer used as
+// a fallback.
+//
+// Deprecated: Use GenerateECKey with a Curve.
 func ECKeys(curve string) (*ecdsa.PrivateKey, error) {
-	switch strings.ToUpper(curve) {
-	default:
-		fallthrough
-	case "P224":
-		return ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
-	case "P256":
-		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	case "P384":
-		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-	case "P521":
-		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
-	}
+	return ecKey(curve)
 }
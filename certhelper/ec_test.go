@@ -1,25 +1,27 @@
-package certhelper
+package certhelper_test
 
 import (
 	e "crypto/elliptic"
 	"testing"
-	"time"
+
+	"github.com/parsiya/go-helpers/certhelper"
+	"github.com/parsiya/go-helpers/certhelper/certtest"
 )
 
 // Based on TestKeyGeneration in ecdsa_test.go
 func TestECKeys(t *testing.T) {
 	curves := map[string]e.Curve{
-		"P224": e.P224(),
-		"P256": e.P256(),
-		"P384": e.P384(),
-		"P521": e.P521(),
-		"P123": e.P224(),
-		"yolo": e.P224(),
+		"P224":       e.P224(),
+		"P256":       e.P256(),
+		"p-384":      e.P384(),
+		"P521":       e.P521(),
+		"prime256v1": e.P256(),
+		"secp384r1":  e.P384(),
 	}
 
 	for curveString, curve := range curves {
 		// Generate key.
-		priv, err := ECKeys(curveString)
+		priv, err := certhelper.ECKeys(curveString)
 		if err != nil {
 			t.Errorf("%s curve error: %s", curveString, err)
 			continue
@@ -30,144 +32,85 @@ func TestECKeys(t *testing.T) {
 			continue
 		}
 	}
+
+	// Unknown curves return an error instead of falling back to P224.
+	for _, curveString := range []string{"P512", "P123", "yolo", ""} {
+		if _, err := certhelper.ECKeys(curveString); err == nil {
+			t.Errorf("%q curve did not return an error", curveString)
+		}
+	}
 }
 
-func TestCustomECRootCA(t *testing.T) {
-	cNames := []string{"cname1", "cname2", "cname3"}
-	orgUnits := []string{"orgunit1", "orguni2", "orgunit3"}
-	serialNumbers := []string{"1", "2", "3"}
-	countryCodes := []string{"US", "CA", "AU"}
-	curves := []string{"P224", "P256", "P384", "P512"}
-	validities := []int{1, 2, 3}
-	maxPathLengths := []int{0, 1, 2}
-	keyUsage := CAKeyUsageConstant
+func TestCurvePolicy(t *testing.T) {
+	certhelper.CurvePolicy = certhelper.ForbidP224
+	defer func() { certhelper.CurvePolicy = nil }()
 
-	for _, commonName := range cNames {
-		for _, orgUnit := range orgUnits {
-			for _, serialNumber := range serialNumbers {
-				for _, countryCode := range countryCodes {
-					for _, curve := range curves {
-						for _, validity := range validities {
-							for _, maxPathLen := range maxPathLengths {
-								// Generate test certificate.
-								cert, _, err := CustomECRootCA(commonName,
-									orgUnit, serialNumber, countryCode,
-									curve, validity, maxPathLen, keyUsage)
-								// Check for errors.
-								if err != nil {
-									t.Errorf("Error in CustomECRootCA(%s, %s, %s, %s, %s, %d, %d)",
-										commonName, orgUnit, serialNumber,
-										countryCode, curve,
-										validity, maxPathLen)
-								}
-								// Check the generated certificate for values.
-								if cert.Subject.CommonName != commonName {
-									t.Errorf("CommonName error: got %s, want %s", cert.Subject.CommonName, commonName)
-									continue
-								}
-								if cert.Subject.OrganizationalUnit[0] != orgUnit {
-									t.Errorf("OrganizationalUnit error: got %s, want %s", cert.Subject.OrganizationalUnit[0], orgUnit)
-									continue
-								}
-								if cert.Subject.SerialNumber != serialNumber {
-									t.Errorf("SerialNumber error: got %s, want %s", cert.Subject.SerialNumber, serialNumber)
-									continue
-								}
-								if cert.Subject.Country[0] != countryCode {
-									t.Errorf("CountryCode error: got %s, want %s", cert.Subject.Country[0], countryCode)
-									continue
-								}
-								// NotBefore and NotAfter checks need current time.
-								currentTime := time.Now().UTC()
-								// Certificate's NotBefore should not be in the future.
-								if cert.NotBefore.After(currentTime) {
-									t.Errorf("NotBefore error: got %s which is after %s", cert.NotBefore, currentTime)
-									continue
-								}
-								// Certificate's NotAfter should not be after currentTime+caValidity.
-								if cert.NotAfter.After(currentTime.AddDate(validity, 0, 0)) {
-									t.Errorf("NotAfter error: got %s which is after %s", cert.NotAfter, currentTime.AddDate(validity, 0, 0))
-									continue
-								}
-								// Check MaxPathLen.
-								if cert.MaxPathLen != maxPathLen {
-									t.Errorf("MaxPathLen error: got %d, want %d", cert.MaxPathLen, maxPathLen)
-									continue
-								}
-							}
-						}
-					}
-				}
-			}
+	if _, err := certhelper.GenerateECKey(certhelper.CurveP224); err == nil {
+		t.Errorf("P224 key generated with ForbidP224")
+	}
+	if _, _, err := certhelper.ECRootCA("root1", "org1", "1", "US", "secp224r1"); err == nil {
+		t.Errorf("P224 root CA generated with ForbidP224")
+	}
+	if _, err := certhelper.GenerateECKey(certhelper.CurveP256); err != nil {
+		t.Errorf("P256 key error: %s", err.Error())
+	}
+	if _, err := certhelper.GenerateECKey(certhelper.Curve(0)); err == nil {
+		t.Errorf("invalid curve did not return an error")
+	}
+	if c, err := certhelper.ParseCurve("SECP521R1"); err != nil || c != certhelper.CurveP521 || c.String() != "P521" {
+		t.Errorf("ParseCurve(SECP521R1) got %s, %v", c, err)
+	}
+}
+
+func TestCustomECRootCA(t *testing.T) {
+	m := certtest.CaseMatrix{
+		CommonNames:   []string{"cname1", "cname2", "cname3"},
+		OrgUnits:      []string{"orgunit1", "orguni2", "orgunit3"},
+		SerialNumbers: []string{"1", "2", "3"},
+		CountryCodes:  []string{"US", "CA", "AU"},
+		Curves:        []string{"P224", "P256", "P384", "P521"},
+		Validities:    []int{1, 2, 3},
+		MaxPathLens:   []int{0, 1, 2},
+		IsCA:          true,
+		KeyUsage:      certhelper.CAKeyUsageConstant,
+	}
+	for _, c := range m.Cases() {
+		// Generate test certificate.
+		cert, _, err := certhelper.CustomECRootCA(c.CommonName, c.OrgUnit, c.SerialNumber,
+			c.CountryCode, c.Curve, c.Validity, c.MaxPathLen, c.KeyUsage)
+		if err != nil {
+			t.Errorf("%s: CustomECRootCA error: %s", c.Name, err.Error())
+			continue
 		}
+		certtest.AssertCert(t, cert, c)
 	}
 }
 
 func TestCustomECLeafCert(t *testing.T) {
 
 	// Create a random CA to sign the leaf certs.
-	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1234", "US", "P256")
+	caCert, caPrivKey, err := certhelper.ECRootCA("root1", "org1", "1234", "US", "P256")
 	if err != nil {
-		t.Errorf("error creating root CA: %s", err.Error())
+		t.Fatalf("error creating root CA: %s", err.Error())
 	}
 
-	cNames := []string{"cname1", "cname2", "cname3"}
-	orgUnits := []string{"orgunit1", "orguni2", "orgunit3"}
-	serialNumbers := []string{"1", "2", "3"}
-	countryCodes := []string{"US", "CA", "AU"}
-	curves := []string{"P224", "P256", "P384", "P512"}
-	validities := []int{1, 2, 3}
-
-	for _, commonName := range cNames {
-		for _, orgUnit := range orgUnits {
-			for _, serialNumber := range serialNumbers {
-				for _, countryCode := range countryCodes {
-					for _, curve := range curves {
-						for _, validity := range validities {
-							// Generate test certificate.
-							cert, _, err := CustomECLeafCert(commonName, orgUnit,
-								serialNumber, countryCode, curve, validity,
-								caCert, caPrivKey)
-							// Check for errors.
-							if err != nil {
-								t.Errorf("Error in CustomECLeafCert(%s, %s, %s, %s, %s, %d)",
-									commonName, orgUnit, serialNumber,
-									countryCode, curve, validity)
-							}
-							// Check the generated certificate for values.
-							if cert.Subject.CommonName != commonName {
-								t.Errorf("CommonName error: got %s, want %s", cert.Subject.CommonName, commonName)
-								continue
-							}
-							if cert.Subject.OrganizationalUnit[0] != orgUnit {
-								t.Errorf("OrganizationalUnit error: got %s, want %s", cert.Subject.OrganizationalUnit[0], orgUnit)
-								continue
-							}
-							if cert.Subject.SerialNumber != serialNumber {
-								t.Errorf("SerialNumber error: got %s, want %s", cert.Subject.SerialNumber, serialNumber)
-								continue
-							}
-							if cert.Subject.Country[0] != countryCode {
-								t.Errorf("CountryCode error: got %s, want %s", cert.Subject.Country[0], countryCode)
-								continue
-							}
-							// NotBefore and NotAfter checks need current time.
-							currentTime := time.Now().UTC()
-							// Certificate's NotBefore should not be in the future.
-							if cert.NotBefore.After(currentTime) {
-								t.Errorf("NotBefore error: got %s which is after %s", cert.NotBefore, currentTime)
-								continue
-							}
-							// Certificate's NotAfter should not be after currentTime+caValidity.
-							if cert.NotAfter.After(currentTime.AddDate(validity, 0, 0)) {
-								t.Errorf("NotAfter error: got %s which is after %s", cert.NotAfter, currentTime.AddDate(validity, 0, 0))
-								continue
-							}
-						}
-					}
-				}
-			}
+	m := certtest.CaseMatrix{
+		CommonNames:   []string{"cname1", "cname2", "cname3"},
+		OrgUnits:      []string{"orgunit1", "orguni2", "orgunit3"},
+		SerialNumbers: []string{"1", "2", "3"},
+		CountryCodes:  []string{"US", "CA", "AU"},
+		Curves:        []string{"P224", "P256", "P384", "P521"},
+		Validities:    []int{1, 2, 3},
+		KeyUsage:      certhelper.LeafKeyUsageConstant,
+	}
+	for _, c := range m.Cases() {
+		// Generate test certificate.
+		cert, _, err := certhelper.CustomECLeafCert(c.CommonName, c.OrgUnit, c.SerialNumber,
+			c.CountryCode, c.Curve, c.Validity, caCert, caPrivKey)
+		if err != nil {
+			t.Errorf("%s: CustomECLeafCert error: %s", c.Name, err.Error())
+			continue
 		}
+		certtest.AssertCert(t, cert, c)
 	}
-
 }
@@ -0,0 +1,221 @@
+package certhelper
+
+// Key agreement and encryption.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// hybridInfo is the HKDF info of EncryptToCert.
+const hybridInfo = "certhelper EncryptToCert"
+
+// hybridCiphertext is the output of EncryptToCert.
+type hybridCiphertext struct {
+	Version int
+	// Recipient is the SHA-256 hash of the recipient's SubjectPublicKeyInfo.
+	Recipient []byte
+	// EncryptedKey is the RSA-OAEP encrypted AES key or the ephemeral EC
+	// public key.
+	EncryptedKey []byte
+	Nonce        []byte
+	Ciphertext   []byte
+}
+
+// ECDHSharedSecret returns the raw ECDH shared secret of privKey and pubKey.
+// The keys must be on the same curve. P224 is not supported. Use DeriveKey to
+// get a key instead of using the secret directly.
+func ECDHSharedSecret(privKey *ecdsa.PrivateKey, pubKey *ecdsa.PublicKey) ([]byte, error) {
+	if privKey.Curve != pubKey.Curve {
+		return nil, fmt.Errorf("keys are on different curves, got %s and %s",
+			privKey.Curve.Params().Name, pubKey.Curve.Params().Name)
+	}
+	priv, err := privKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, err.Error())
+	}
+	pub, err := pubKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, err.Error())
+	}
+	return priv.ECDH(pub)
+}
+
+// DeriveKey returns a length byte key derived from the ECDH shared secret of
+// privKey and pubKey with HKDF-SHA256. salt and info are the HKDF parameters
+// and can be nil. Both sides get the same key.
+func DeriveKey(privKey *ecdsa.PrivateKey, pubKey *ecdsa.PublicKey, salt, info []byte,
+	length int) ([]byte, error) {
+
+	secret, err := ECDHSharedSecret(privKey, pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return hkdfKey(secret, salt, info, length)
+}
+
+// RSAEncryptOAEP encrypts msg for pubKey with RSA-OAEP and SHA-256. label
+// can be nil and must be the same when decrypting. msg must be shorter than
+// the key size in bytes minus 66, use EncryptToCert for longer messages.
+func RSAEncryptOAEP(pubKey *rsa.PublicKey, msg, label []byte) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, pubKey, msg, label)
+}
+
+// RSADecryptOAEP decrypts ciphertext from RSAEncryptOAEP with privKey.
+func RSADecryptOAEP(privKey *rsa.PrivateKey, ciphertext, label []byte) ([]byte, error) {
+	msg, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privKey, ciphertext, label)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+	}
+	return msg, nil
+}
+
+// EncryptToCert encrypts msg so it can only be decrypted with the private key
+// of cert. msg is encrypted with AES-256-GCM and a random key. For RSA
+// certificates, the key is encrypted with RSA-OAEP. For EC certificates, the
+// key is derived with DeriveKey from an ephemeral key. The result is DER and
+// can be decrypted with DecryptWithKey.
+func EncryptToCert(cert *x509.Certificate, msg []byte) ([]byte, error) {
+	recipient := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	ct := hybridCiphertext{Version: 1, Recipient: recipient[:]}
+
+	var key []byte
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		encKey, err := RSAEncryptOAEP(pub, key, recipient[:])
+		if err != nil {
+			return nil, err
+		}
+		ct.EncryptedKey = encKey
+	case *ecdsa.PublicKey:
+		ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		ephemeralPub, err := ephemeral.PublicKey.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, err.Error())
+		}
+		ct.EncryptedKey = ephemeralPub.Bytes()
+		key, err = DeriveKey(ephemeral, pub, ct.EncryptedKey, []byte(hybridInfo), 32)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &KeyTypeError{Param: "certificate public key", Key: cert.PublicKey}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	ct.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(ct.Nonce); err != nil {
+		return nil, err
+	}
+	ct.Ciphertext = aead.Seal(nil, ct.Nonce, msg, hybridAAD(ct))
+	return asn1.Marshal(ct)
+}
+
+// DecryptWithKey decrypts data from EncryptToCert with privKey, the RSA or EC
+// private key of the certificate.
+func DecryptWithKey(privKey interface{}, data []byte) ([]byte, error) {
+	var ct hybridCiphertext
+	rest, err := asn1.Unmarshal(data, &ct)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+	}
+	if len(rest) != 0 || ct.Version != 1 {
+		return nil, fmt.Errorf("%w: invalid ciphertext", ErrDecryption)
+	}
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+	if recipient := sha256.Sum256(spki); !bytes.Equal(recipient[:], ct.Recipient) {
+		return nil, fmt.Errorf("%w: encrypted for another key", ErrDecryption)
+	}
+
+	var key []byte
+	switch k := privKey.(type) {
+	case *rsa.PrivateKey:
+		key, err = RSADecryptOAEP(k, ct.EncryptedKey, ct.Recipient)
+		if err != nil {
+			return nil, err
+		}
+	case *ecdsa.PrivateKey:
+		priv, err := k.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, err.Error())
+		}
+		ephemeral, err := priv.Curve().NewPublicKey(ct.EncryptedKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid ephemeral key", ErrDecryption)
+		}
+		secret, err := priv.ECDH(ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+		}
+		key, err = hkdfKey(secret, ct.EncryptedKey, []byte(hybridInfo), 32)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ct.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrDecryption)
+	}
+	msg, err := aead.Open(nil, ct.Nonce, ct.Ciphertext, hybridAAD(ct))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecryption, err.Error())
+	}
+	return msg, nil
+}
+
+// hkdfKey returns a length byte key from secret with HKDF-SHA256.
+func hkdfKey(secret, salt, info []byte, length int) ([]byte, error) {
+	key := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// hybridAAD authenticates the recipient and encrypted key of ct.
+func hybridAAD(ct hybridCiphertext) []byte {
+	return append(append([]byte(nil), ct.Recipient...), ct.EncryptedKey...)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,109 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	alice, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	bob, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	key1, err := DeriveKey(alice, &bob.PublicKey, []byte("salt"), []byte("info"), 32)
+	if err != nil {
+		t.Fatalf("DeriveKey error: %s", err.Error())
+	}
+	key2, err := DeriveKey(bob, &alice.PublicKey, []byte("salt"), []byte("info"), 32)
+	if err != nil {
+		t.Fatalf("DeriveKey error: %s", err.Error())
+	}
+	if len(key1) != 32 || !bytes.Equal(key1, key2) {
+		t.Errorf("derived keys do not match, got %x and %x", key1, key2)
+	}
+	key3, err := DeriveKey(alice, &bob.PublicKey, []byte("salt"), []byte("other"), 32)
+	if err != nil {
+		t.Fatalf("DeriveKey error: %s", err.Error())
+	}
+	if bytes.Equal(key1, key3) {
+		t.Errorf("info did not change the key")
+	}
+
+	other, err := GenerateECKey(CurveP384)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	if _, err := ECDHSharedSecret(alice, &other.PublicKey); err == nil {
+		t.Errorf("ECDH with keys on different curves did not return an error")
+	}
+}
+
+func TestRSAOAEP(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	ct, err := RSAEncryptOAEP(&key.PublicKey, []byte("secret"), []byte("label"))
+	if err != nil {
+		t.Fatalf("RSAEncryptOAEP error: %s", err.Error())
+	}
+	msg, err := RSADecryptOAEP(key, ct, []byte("label"))
+	if err != nil || string(msg) != "secret" {
+		t.Errorf("RSADecryptOAEP got %q, %v", msg, err)
+	}
+	if _, err := RSADecryptOAEP(key, ct, nil); !errors.Is(err, ErrDecryption) {
+		t.Errorf("decrypted with the wrong label, got %v", err)
+	}
+}
+
+func TestEncryptToCert(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	ecKey, err := GenerateECKey(CurveP384)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	msg := bytes.Repeat([]byte("secret"), 100)
+
+	for _, key := range []interface{}{rsaKey, ecKey} {
+		cert, err := CustomLeafCertWithKey("service", "org1", "2", "US", 1,
+			key.(crypto.Signer), caCert, caPrivKey)
+		if err != nil {
+			t.Fatalf("%T: error creating leaf: %s", key, err.Error())
+		}
+		ct, err := EncryptToCert(cert, msg)
+		if err != nil {
+			t.Fatalf("%T: EncryptToCert error: %s", key, err.Error())
+		}
+		got, err := DecryptWithKey(key, ct)
+		if err != nil {
+			t.Fatalf("%T: DecryptWithKey error: %s", key, err.Error())
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("%T: bad plaintext, got %q", key, got)
+		}
+
+		// Wrong key and modified ciphertext.
+		if _, err := DecryptWithKey(caPrivKey, ct); !errors.Is(err, ErrDecryption) {
+			t.Errorf("%T: decrypted with the wrong key, got %v", key, err)
+		}
+		ct[len(ct)-1] ^= 1
+		if _, err := DecryptWithKey(key, ct); !errors.Is(err, ErrDecryption) {
+			t.Errorf("%T: decrypted a modified ciphertext, got %v", key, err)
+		}
+	}
+}
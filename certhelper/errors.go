@@ -0,0 +1,134 @@
+package certhelper
+
+// Error values.
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// Sentinel errors. Use errors.Is to check the kind of a returned error and
+// errors.As with the error types below for details.
+var (
+	// ErrUnsupportedKeyType is matched by *KeyTypeError.
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+	// ErrUnsupportedAlgorithm is returned for unknown key or hash algorithms.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrInvalidSerial is matched by *SerialError.
+	ErrInvalidSerial = errors.New("invalid serial number")
+	// ErrEncoding is matched by *EncodingError.
+	ErrEncoding = errors.New("encoding failed")
+	// ErrInvalidFormat is returned for data that is not valid DER, PEM or
+	// base64.
+	ErrInvalidFormat = errors.New("invalid format")
+	// ErrFileExists is matched by *FileExistsError.
+	ErrFileExists = errors.New("file exists")
+	// ErrUnknownCurve is returned for unknown EC curve names.
+	ErrUnknownCurve = errors.New("unknown curve")
+	// ErrForbiddenCurve is returned when CurvePolicy rejects a curve.
+	ErrForbiddenCurve = errors.New("curve is forbidden by policy")
+	// ErrDecryption is returned when a ciphertext can not be decrypted, e.g.,
+	// it was encrypted for another key or modified.
+	ErrDecryption = errors.New("decryption failed")
+	// ErrKeyNotFound is returned when a KeyStore does not have a label.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned when a KeyStore label is already used.
+	ErrKeyExists = errors.New("key exists")
+)
+
+// KeyTypeError is returned when a key has an unsupported type.
+type KeyTypeError struct {
+	// Param is the parameter or kind of key, e.g. "caPrivKey".
+	Param string
+	Key   interface{}
+}
+
+func (e *KeyTypeError) Error() string {
+	return fmt.Sprintf("invalid %s, got %T", e.Param, e.Key)
+}
+
+// Is returns true for ErrUnsupportedKeyType.
+func (e *KeyTypeError) Is(target error) bool {
+	return target == ErrUnsupportedKeyType
+}
+
+// SerialError is returned when a serial number string can not be parsed.
+type SerialError struct {
+	Serial string
+	Err    error
+}
+
+func (e *SerialError) Error() string {
+	return fmt.Sprintf("invalid serial number %q: %s", e.Serial, e.Err.Error())
+}
+
+// Unwrap returns the parse error.
+func (e *SerialError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrInvalidSerial.
+func (e *SerialError) Is(target error) bool {
+	return target == ErrInvalidSerial
+}
+
+// EncodingError is returned when encoding to Format fails. Err is the cause
+// and can be nil.
+type EncodingError struct {
+	Format string
+	Err    error
+}
+
+func (e *EncodingError) Error() string {
+	if e.Err == nil {
+		return e.Format + " encoding failed"
+	}
+	return e.Format + " encoding failed: " + e.Err.Error()
+}
+
+// Unwrap returns the cause.
+func (e *EncodingError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrEncoding.
+func (e *EncodingError) Is(target error) bool {
+	return target == ErrEncoding
+}
+
+// FileExistsError is returned when a file exists and is not overwritten.
+type FileExistsError struct {
+	Path string
+}
+
+func (e *FileExistsError) Error() string {
+	return e.Path + " exists and overwrite is not set"
+}
+
+// Is returns true for ErrFileExists and os.ErrExist.
+func (e *FileExistsError) Is(target error) bool {
+	return target == ErrFileExists || target == os.ErrExist
+}
+
+// parseSerial converts a decimal serial number string to a big int.
+func parseSerial(serialNumber string) (*big.Int, error) {
+	sn, ok := new(big.Int).SetString(serialNumber, 10)
+	if !ok {
+		return nil, &SerialError{Serial: serialNumber, Err: strconv.ErrSyntax}
+	}
+	return sn, nil
+}
+
+// writeFile is filehelper.WriteFile that returns a *FileExistsError if file
+// exists and overwrite is not set.
+func writeFile(data []byte, file string, overwrite bool) error {
+	if !overwrite && filehelper.FileExists(file) {
+		return &FileExistsError{Path: file}
+	}
+	return filehelper.WriteFile(data, file, overwrite)
+}
@@ -0,0 +1,93 @@
+package certhelper
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+
+	// Invalid serial numbers wrap the strconv error.
+	_, _, err = ECLeafCert("leaf1", "org1", "abc", "US", "P256", caCert, caPrivKey)
+	var serialErr *SerialError
+	if !errors.Is(err, ErrInvalidSerial) || !errors.Is(err, strconv.ErrSyntax) ||
+		!errors.As(err, &serialErr) || serialErr.Serial != "abc" {
+		t.Errorf("bad serial error, got %v", err)
+	}
+	// Serial numbers can be larger than an int.
+	serial, err := randomSerial()
+	if err != nil {
+		t.Fatalf("randomSerial error: %s", err.Error())
+	}
+	if leaf, _, err := ECLeafCert("leaf1", "org1", serial, "US", "P256", caCert,
+		caPrivKey); err != nil || leaf.SerialNumber.String() != serial {
+		t.Errorf("bad certificate for serial %s, got %v", serial, err)
+	}
+	if other, _ := randomSerial(); other == serial {
+		t.Errorf("randomSerial returned the same serial twice")
+	}
+
+	// Key types.
+	_, _, err = ECLeafCert("leaf1", "org1", "2", "US", "P256", caCert, "not a key")
+	var keyErr *KeyTypeError
+	if !errors.Is(err, ErrUnsupportedKeyType) || !errors.As(err, &keyErr) ||
+		keyErr.Param != "caPrivKey" {
+		t.Errorf("bad key type error, got %v", err)
+	}
+	if _, err := KeyToPEM(42); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("bad KeyToPEM error, got %v", err)
+	}
+
+	// Algorithms and curves.
+	if _, err := CATemplate("root1", "org1", "1", "US", "DSA"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("bad algorithm error, got %v", err)
+	}
+	if _, err := ECKeys("yolo"); !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("bad curve error, got %v", err)
+	}
+	CurvePolicy = ForbidP224
+	_, err = GenerateECKey(CurveP224)
+	CurvePolicy = nil
+	if !errors.Is(err, ErrForbiddenCurve) {
+		t.Errorf("bad curve policy error, got %v", err)
+	}
+
+	// Encoding.
+	_, err = CustomCATemplate("root1", "org1", "1", "US", "EC", 1, 0,
+		CAKeyUsageConstant, WithASN1Extension(OIDSubjectKeyID, false, make(chan int)))
+	var encErr *EncodingError
+	if !errors.Is(err, ErrEncoding) || !errors.As(err, &encErr) || encErr.Err == nil {
+		t.Errorf("bad encoding error, got %v", err)
+	}
+
+	// Existing files.
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "root.crt")
+	if err := CertToPEMFile(caCert, file); err != nil {
+		t.Fatalf("CertToPEMFile error: %s", err.Error())
+	}
+	err = CertToPEMFile(caCert, file)
+	var existsErr *FileExistsError
+	if !errors.Is(err, ErrFileExists) || !errors.Is(err, os.ErrExist) ||
+		!errors.As(err, &existsErr) || existsErr.Path != file {
+		t.Errorf("bad file exists error, got %v", err)
+	}
+
+	// Errors are wrapped by higher level functions.
+	spec := &PKISpec{CAs: []CertSpec{{Name: "root", CommonName: "root", SerialNumber: "x"}}}
+	if _, err := GeneratePKI(spec, dir); !errors.Is(err, ErrInvalidSerial) {
+		t.Errorf("bad GeneratePKI error, got %v", err)
+	}
+}
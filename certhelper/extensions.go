@@ -0,0 +1,199 @@
+package certhelper
+
+// Template options and custom x509 extensions.
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+)
+
+var (
+	// OIDSubjectKeyID is the Subject Key Identifier extension.
+	OIDSubjectKeyID = asn1.ObjectIdentifier{2, 5, 29, 14}
+	// OIDAuthorityKeyID is the Authority Key Identifier extension.
+	OIDAuthorityKeyID = asn1.ObjectIdentifier{2, 5, 29, 35}
+	// OIDCRLDistributionPoints is the CRL Distribution Points extension.
+	OIDCRLDistributionPoints = asn1.ObjectIdentifier{2, 5, 29, 31}
+	// OIDAuthorityInfoAccess is the Authority Information Access extension.
+	OIDAuthorityInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+)
+
+// TemplateOption modifies a certificate template. Options are passed to
+// CustomCATemplate, CustomLeafTemplate and the functions that use them.
+type TemplateOption func(*x509.Certificate) error
+
+// WithCRLDistributionPoints adds CRL distribution point URLs.
+func WithCRLDistributionPoints(urls ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.CRLDistributionPoints = append(cert.CRLDistributionPoints, urls...)
+		return nil
+	}
+}
+
+// WithOCSPServers adds OCSP responder URLs to the Authority Information Access
+// extension.
+func WithOCSPServers(urls ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.OCSPServer = append(cert.OCSPServer, urls...)
+		return nil
+	}
+}
+
+// WithIssuingCertificateURLs adds CA issuers URLs to the Authority Information
+// Access extension.
+func WithIssuingCertificateURLs(urls ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.IssuingCertificateURL = append(cert.IssuingCertificateURL, urls...)
+		return nil
+	}
+}
+
+// WithDNSNames adds DNS subject alternative names.
+func WithDNSNames(names ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.DNSNames = append(cert.DNSNames, names...)
+		return nil
+	}
+}
+
+// WithIPAddresses adds IP address subject alternative names. For example,
+// "127.0.0.1" or "::1".
+func WithIPAddresses(ips ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		for _, s := range ips {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address, got %s", s)
+			}
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		}
+		return nil
+	}
+}
+
+// WithEmailAddresses adds email subject alternative names.
+func WithEmailAddresses(emails ...string) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.EmailAddresses = append(cert.EmailAddresses, emails...)
+		return nil
+	}
+}
+
+// WithExtKeyUsage replaces the default x509.ExtKeyUsageAny extended key usage.
+func WithExtKeyUsage(usages ...x509.ExtKeyUsage) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.ExtKeyUsage = usages
+		return nil
+	}
+}
+
+// WithSubjectKeyID overrides the Subject Key Identifier. By default, Go
+// generates one for CA certificates.
+func WithSubjectKeyID(id []byte) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		if len(id) == 0 {
+			return fmt.Errorf("empty subject key identifier")
+		}
+		cert.SubjectKeyId = id
+		return nil
+	}
+}
+
+// WithAuthorityKeyID overrides the Authority Key Identifier. By default, Go
+// copies the Subject Key Identifier of the parent and ignores
+// x509.Certificate.AuthorityKeyId so this option adds a raw extension.
+func WithAuthorityKeyID(id []byte) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		if len(id) == 0 {
+			return fmt.Errorf("empty authority key identifier")
+		}
+		// AuthorityKeyIdentifier ::= SEQUENCE { keyIdentifier [0] IMPLICIT OCTET STRING }
+		aki := struct {
+			ID []byte `asn1:"optional,tag:0"`
+		}{id}
+		value, err := asn1.Marshal(aki)
+		if err != nil {
+			return err
+		}
+		cert.AuthorityKeyId = id
+		addExtension(cert, pkix.Extension{Id: OIDAuthorityKeyID, Value: value})
+		return nil
+	}
+}
+
+// WithExtension adds an extension with a DER encoded value. Extensions
+// generated by Go with the same OID are replaced.
+func WithExtension(oid asn1.ObjectIdentifier, critical bool, value []byte) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		addExtension(cert, pkix.Extension{Id: oid, Critical: critical, Value: value})
+		return nil
+	}
+}
+
+// WithASN1Extension adds an extension and encodes value with asn1.Marshal.
+// For example, a string value becomes a PrintableString or UTF8String.
+func WithASN1Extension(oid asn1.ObjectIdentifier, critical bool, value interface{}) TemplateOption {
+	return func(cert *x509.Certificate) error {
+		b, err := asn1.Marshal(value)
+		if err != nil {
+			return &EncodingError{Format: "extension " + oid.String(), Err: err}
+		}
+		addExtension(cert, pkix.Extension{Id: oid, Critical: critical, Value: b})
+		return nil
+	}
+}
+
+// CertExtension returns the extension with oid from cert and true if it
+// exists.
+func CertExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) (pkix.Extension, bool) {
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oid) {
+			return e, true
+		}
+	}
+	return pkix.Extension{}, false
+}
+
+// UnmarshalCertExtension finds the extension with oid in cert and decodes its
+// value into out with asn1.Unmarshal. Returns the extension's critical flag.
+func UnmarshalCertExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier,
+	out interface{}) (critical bool, err error) {
+
+	ext, ok := CertExtension(cert, oid)
+	if !ok {
+		return false, fmt.Errorf("extension %s not found", oid)
+	}
+	rest, err := asn1.Unmarshal(ext.Value, out)
+	if err != nil {
+		return ext.Critical, err
+	}
+	if len(rest) != 0 {
+		return ext.Critical, fmt.Errorf("trailing data after extension %s", oid)
+	}
+	return ext.Critical, nil
+}
+
+// addExtension adds ext to cert.ExtraExtensions. An existing extension with
+// the same OID is replaced.
+func addExtension(cert *x509.Certificate, ext pkix.Extension) {
+	for i, e := range cert.ExtraExtensions {
+		if e.Id.Equal(ext.Id) {
+			cert.ExtraExtensions[i] = ext
+			return
+		}
+	}
+	cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+}
+
+// applyOptions applies opts to cert in order.
+func applyOptions(cert *x509.Certificate, opts []TemplateOption) error {
+	for _, opt := range opts {
+		if err := opt(cert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package certhelper
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestTemplateOptions(t *testing.T) {
+	deviceOID := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555, 1}
+	caCert, caPrivKey, err := CustomECRootCA("root1", "org1", "1234", "US",
+		"P256", 1, 0, CAKeyUsageConstant,
+		WithSubjectKeyID([]byte{1, 2, 3, 4}))
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	if !bytes.Equal(caCert.SubjectKeyId, []byte{1, 2, 3, 4}) {
+		t.Errorf("SubjectKeyId error: got %x", caCert.SubjectKeyId)
+	}
+
+	cert, _, err := CustomECLeafCert("leaf1", "org1", "2", "US", "P256", 1,
+		caCert, caPrivKey,
+		WithCRLDistributionPoints("http://crl.example.com/root.crl"),
+		WithOCSPServers("http://ocsp.example.com"),
+		WithIssuingCertificateURLs("http://example.com/root.crt"),
+		WithAuthorityKeyID([]byte{5, 6, 7, 8}),
+		WithASN1Extension(deviceOID, false, "device-1234"))
+	if err != nil {
+		t.Fatalf("error in CustomECLeafCert: %s", err.Error())
+	}
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != "http://crl.example.com/root.crl" {
+		t.Errorf("CRLDistributionPoints error: got %v", cert.CRLDistributionPoints)
+	}
+	if len(cert.OCSPServer) != 1 || len(cert.IssuingCertificateURL) != 1 {
+		t.Errorf("AIA error: got %v and %v", cert.OCSPServer, cert.IssuingCertificateURL)
+	}
+	// The override must replace the generated AKI.
+	if !bytes.Equal(cert.AuthorityKeyId, []byte{5, 6, 7, 8}) {
+		t.Errorf("AuthorityKeyId error: got %x", cert.AuthorityKeyId)
+	}
+	var deviceID string
+	critical, err := UnmarshalCertExtension(cert, deviceOID, &deviceID)
+	if err != nil {
+		t.Fatalf("error in UnmarshalCertExtension: %s", err.Error())
+	}
+	if critical || deviceID != "device-1234" {
+		t.Errorf("device extension error: got %s, critical %t", deviceID, critical)
+	}
+}
@@ -1,3 +1,11 @@
 module github.com/parsiya/go-helpers/certhelper
 
-go 1.14
+go 1.20 // crypto/ecdh for ECDHSharedSecret and EncryptToCert.
+
+require (
+	golang.org/x/crypto v0.11.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.4.0
+)
+
+require golang.org/x/sys v0.10.0 // indirect
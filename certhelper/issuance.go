@@ -0,0 +1,215 @@
+package certhelper
+
+// Certificate issuance clients.
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+)
+
+// IssueRequest is a request for a certificate.
+type IssueRequest struct {
+	// Names are DNS names or IP addresses. The first name is also the common
+	// name.
+	Names []string
+	// Key describes the new private key. Default is an EC P-256 key.
+	Key KeySpec
+}
+
+// IssuedCert is a certificate from an IssuanceClient.
+type IssuedCert struct {
+	Cert *x509.Certificate
+	// Chain are the CA certificates starting with the issuer of Cert.
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// IssuanceClient requests certificates from a CA. LocalCA implements it for
+// tests, an ACME client can implement it in production.
+type IssuanceClient interface {
+	// Issue returns a new certificate and key for req.
+	Issue(ctx context.Context, req IssueRequest) (*IssuedCert, error)
+	// Renew returns a new certificate and key with the names and key type of
+	// old. See RenewalRequest.
+	Renew(ctx context.Context, old *IssuedCert) (*IssuedCert, error)
+}
+
+// LocalCA is an IssuanceClient that issues certificates with a local CA, for
+// example from RSARootCA or ECRootCA. Certificates can be used for server and
+// client authentication.
+type LocalCA struct {
+	Cert *x509.Certificate
+	Key  interface{}
+	// Chain is added to the chain of issued certificates after Cert, e.g.,
+	// the root if Cert is an intermediate.
+	Chain       []*x509.Certificate
+	OrgUnit     string
+	CountryCode string
+	// Lifetime of issued certificates. Default is IssuedLifetimeConstant.
+	Lifetime time.Duration
+}
+
+// NewLocalCA returns a LocalCA that signs with caCert and caPrivKey.
+func NewLocalCA(caCert *x509.Certificate, caPrivKey interface{}) *LocalCA {
+	return &LocalCA{
+		Cert:        caCert,
+		Key:         caPrivKey,
+		OrgUnit:     "certhelper",
+		CountryCode: "US",
+		Lifetime:    IssuedLifetimeConstant,
+	}
+}
+
+// Issue returns a new certificate for req signed by the CA.
+func (c *LocalCA) Issue(ctx context.Context, req IssueRequest) (*IssuedCert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(req.Names) == 0 {
+		return nil, fmt.Errorf("no names in request")
+	}
+	spec := req.Key
+	if spec.Algo == "" {
+		spec.Algo = "EC"
+	}
+	key, err := generateSpecKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	lifetime := c.Lifetime
+	if lifetime == 0 {
+		lifetime = IssuedLifetimeConstant
+	}
+	opts := []TemplateOption{
+		WithExtKeyUsage(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth),
+		func(cert *x509.Certificate) error {
+			cert.NotAfter = cert.NotBefore.Add(lifetime)
+			return nil
+		},
+	}
+	for _, name := range req.Names {
+		if ip := net.ParseIP(name); ip != nil {
+			opts = append(opts, WithIPAddresses(name))
+		} else {
+			opts = append(opts, WithDNSNames(name))
+		}
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := CustomLeafCertWithKey(req.Names[0], c.OrgUnit, serial,
+		c.CountryCode, CertValidityConstant, key, c.Cert, c.Key, opts...)
+	if err != nil {
+		return nil, err
+	}
+	chain := append([]*x509.Certificate{c.Cert}, c.Chain...)
+	return &IssuedCert{Cert: cert, Chain: chain, Key: key}, nil
+}
+
+// Renew returns a new certificate for the names and key type of old.
+func (c *LocalCA) Renew(ctx context.Context, old *IssuedCert) (*IssuedCert, error) {
+	req, err := RenewalRequest(old)
+	if err != nil {
+		return nil, err
+	}
+	return c.Issue(ctx, req)
+}
+
+// RenewalRequest returns a request for the DNS names, IP addresses and key
+// type of ic. Returns an error if the key is not RSA or on a supported EC
+// curve.
+func RenewalRequest(ic *IssuedCert) (IssueRequest, error) {
+	req := IssueRequest{Names: append([]string(nil), ic.Cert.DNSNames...)}
+	for _, ip := range ic.Cert.IPAddresses {
+		req.Names = append(req.Names, ip.String())
+	}
+	switch pub := ic.Cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		req.Key = KeySpec{Algo: "RSA", KeySize: pub.N.BitLen()}
+	case *ecdsa.PublicKey:
+		curve, err := ParseCurve(pub.Curve.Params().Name)
+		if err != nil {
+			return IssueRequest{}, err
+		}
+		req.Key = KeySpec{Algo: "EC", Curve: curve}
+	default:
+		return IssueRequest{}, &KeyTypeError{Param: "ic", Key: pub}
+	}
+	return req, nil
+}
+
+// NeedsRenewal returns true if less than renewBefore of ic's lifetime is left
+// at now. For example, 0.3 returns true when 30% of the lifetime is left.
+func (ic *IssuedCert) NeedsRenewal(renewBefore float64, now time.Time) bool {
+	return needsRenewal(ic.Cert, renewBefore, now)
+}
+
+// RenewIfNeeded renews ic with client if it needs renewal at the current
+// time. Returns ic and false if it was not renewed.
+func RenewIfNeeded(ctx context.Context, client IssuanceClient, ic *IssuedCert,
+	renewBefore float64) (*IssuedCert, bool, error) {
+
+	if !ic.NeedsRenewal(renewBefore, time.Now()) {
+		return ic, false, nil
+	}
+	renewed, err := client.Renew(ctx, ic)
+	if err != nil {
+		return ic, false, err
+	}
+	return renewed, true, nil
+}
+
+// WritePEMFiles stores the certificate followed by the chain in certFile and
+// the key in keyFile. Existing files are replaced so renewed certificates
+// can be written to the same files. The certificate is replaced last, readers
+// can briefly see the new key with the old certificate.
+func (ic *IssuedCert) WritePEMFiles(certFile, keyFile string) error {
+	certPEM, err := chainToPEM(ic.Cert, ic.Chain)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := KeyToPEM(ic.Key)
+	if err != nil {
+		return err
+	}
+	return replaceKeyPair(certFile, certPEM, keyFile, keyPEM)
+}
+
+// LoadIssuedCert reads a certificate, chain and key stored by WritePEMFiles.
+func LoadIssuedCert(certFile, keyFile string) (*IssuedCert, error) {
+	certs, keys, err := ParsePEMFiles(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 || len(keys) != 1 {
+		return nil, fmt.Errorf("want certificates and one key, got %d certificates and %d keys",
+			len(certs), len(keys))
+	}
+	key, ok := keys[0].(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: "key", Key: keys[0]}
+	}
+	if match, err := KeyMatchesCert(certs[0], key); err != nil || !match {
+		return nil, fmt.Errorf("%s does not match the certificate in %s", keyFile, certFile)
+	}
+	return &IssuedCert{Cert: certs[0], Chain: certs[1:], Key: key}, nil
+}
+
+// ClientIssuer returns an IssueFunc that requests req from client, so a
+// Rotator can renew certificates with an IssuanceClient.
+func ClientIssuer(ctx context.Context, client IssuanceClient, req IssueRequest) IssueFunc {
+	return func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		ic, err := client.Issue(ctx, req)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return ic.Cert, ic.Chain, ic.Key, nil
+	}
+}
@@ -0,0 +1,167 @@
+package certhelper
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var _ IssuanceClient = (*LocalCA)(nil)
+
+func TestLocalCA(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	ca := NewLocalCA(caCert, caPrivKey)
+	ctx := context.Background()
+
+	ic, err := ca.Issue(ctx, IssueRequest{Names: []string{"app.test", "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Issue error: %s", err.Error())
+	}
+	opts := x509.VerifyOptions{DNSName: "app.test", Roots: x509.NewCertPool()}
+	opts.Roots.AddCert(caCert)
+	if _, err := ic.Cert.Verify(opts); err != nil {
+		t.Errorf("error verifying certificate: %s", err.Error())
+	}
+	if ic.Cert.Subject.CommonName != "app.test" || len(ic.Cert.IPAddresses) != 1 {
+		t.Errorf("bad names, got %s and %v", ic.Cert.Subject.CommonName, ic.Cert.IPAddresses)
+	}
+	if len(ic.Chain) != 1 || !ic.Chain[0].Equal(caCert) {
+		t.Errorf("bad chain, got %d certificates", len(ic.Chain))
+	}
+	if _, ok := ic.Key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("default key is not EC, got %T", ic.Key)
+	}
+	if lifetime := ic.Cert.NotAfter.Sub(ic.Cert.NotBefore); lifetime != IssuedLifetimeConstant {
+		t.Errorf("bad lifetime, got %s", lifetime)
+	}
+
+	// Renewals keep the names and key type.
+	ca.Lifetime = time.Hour
+	rsaIC, err := ca.Issue(ctx, IssueRequest{Names: []string{"rsa.test"},
+		Key: KeySpec{Algo: "RSA", KeySize: 2048}})
+	if err != nil {
+		t.Fatalf("Issue error: %s", err.Error())
+	}
+	renewed, ok, err := RenewIfNeeded(ctx, ca, rsaIC, RenewBeforeConstant)
+	if err != nil || ok || renewed != rsaIC {
+		t.Errorf("renewed a new certificate, got %v, %v", ok, err)
+	}
+	renewed, ok, err = RenewIfNeeded(ctx, ca, rsaIC, 1)
+	if err != nil || !ok {
+		t.Fatalf("certificate was not renewed, got %v, %v", ok, err)
+	}
+	if k, isRSA := renewed.Key.(*rsa.PrivateKey); !isRSA || k.N.BitLen() != 2048 {
+		t.Errorf("renewal changed the key type, got %T", renewed.Key)
+	}
+	if renewed.Cert.SerialNumber.Cmp(rsaIC.Cert.SerialNumber) == 0 ||
+		renewed.Cert.DNSNames[0] != "rsa.test" {
+		t.Errorf("bad renewed certificate: %s %v", renewed.Cert.SerialNumber, renewed.Cert.DNSNames)
+	}
+
+	if _, err := ca.Issue(ctx, IssueRequest{}); err == nil {
+		t.Errorf("issued a certificate without names")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ca.Issue(canceled, IssueRequest{Names: []string{"app.test"}}); err == nil {
+		t.Errorf("issued a certificate with a canceled context")
+	}
+}
+
+func TestIssuedCertPEMFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	ca := NewLocalCA(caCert, caPrivKey)
+	certFile := filepath.Join(dir, "app.crt")
+	keyFile := filepath.Join(dir, "app.key")
+
+	// The second write replaces the files.
+	var ic *IssuedCert
+	for i := 0; i < 2; i++ {
+		ic, err = ca.Issue(context.Background(), IssueRequest{Names: []string{"app.test"}})
+		if err != nil {
+			t.Fatalf("Issue error: %s", err.Error())
+		}
+		if err := ic.WritePEMFiles(certFile, keyFile); err != nil {
+			t.Fatalf("WritePEMFiles error: %s", err.Error())
+		}
+	}
+	loaded, err := LoadIssuedCert(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadIssuedCert error: %s", err.Error())
+	}
+	if !loaded.Cert.Equal(ic.Cert) || len(loaded.Chain) != 1 || !loaded.Chain[0].Equal(caCert) {
+		t.Errorf("loaded certificate does not match")
+	}
+	if ok, _ := KeyMatchesCert(loaded.Cert, loaded.Key); !ok {
+		t.Errorf("loaded key does not match")
+	}
+}
+
+func TestClientIssuer(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	r, err := NewRotator(ClientIssuer(context.Background(), NewLocalCA(caCert, caPrivKey),
+		IssueRequest{Names: []string{"app.test"}}))
+	if err != nil {
+		t.Fatalf("NewRotator error: %s", err.Error())
+	}
+	if cert := r.Certificate(); cert.Subject.CommonName != "app.test" {
+		t.Errorf("bad rotator certificate, got %s", cert.Subject.CommonName)
+	}
+	// The issued chain is served after the certificate.
+	got, _ := r.GetCertificate(&tls.ClientHelloInfo{})
+	if len(got.Certificate) != 2 || !bytes.Equal(got.Certificate[1], caCert.Raw) {
+		t.Errorf("rotator does not serve the issued chain, got %d certificates", len(got.Certificate))
+	}
+}
+
+func TestRenewalRequest(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P384")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	req, err := RenewalRequest(&IssuedCert{Cert: caCert, Key: caPrivKey})
+	if err != nil || req.Key.Algo != "EC" || req.Key.Curve != CurveP384 {
+		t.Errorf("bad renewal request, got %+v, %v", req.Key, err)
+	}
+
+	// Unsupported curves and key types are not replaced with P-256.
+	p192 := &x509.Certificate{PublicKey: &ecdsa.PublicKey{
+		Curve: &elliptic.CurveParams{Name: "P-192"}}}
+	if _, err := RenewalRequest(&IssuedCert{Cert: p192}); !errors.Is(err, ErrUnknownCurve) {
+		t.Errorf("renewal request for an unknown curve, got %v", err)
+	}
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edCert := &x509.Certificate{PublicKey: pub}
+	if _, err := RenewalRequest(&IssuedCert{Cert: edCert}); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("renewal request for an Ed25519 key, got %v", err)
+	}
+}
@@ -0,0 +1,552 @@
+package certhelper
+
+// Java keystores (JKS and JCEKS).
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// JKSType is a Java keystore format.
+type JKSType int
+
+// Java keystore formats.
+const (
+	// JKS is the Java keystore format used by keytool before Java 9.
+	JKS JKSType = iota
+	// JCEKS protects keys with PBEWithMD5AndTripleDES instead of the JKS
+	// XOR scheme.
+	JCEKS
+)
+
+func (t JKSType) String() string {
+	if t == JCEKS {
+		return "JCEKS"
+	}
+	return "JKS"
+}
+
+const (
+	jksMagic       = 0xfeedfeed
+	jceksMagic     = 0xcececece
+	jksVersion     = 2
+	jksKeyTag      = 1
+	jksCertTag     = 2
+	jceksSecretTag = 3
+	jksCertType    = "X.509"
+	// Appended to the password in the integrity digest by
+	// sun.security.provider.JavaKeyStore.
+	jksWhitener = "Mighty Aphrodite"
+	// Same as the JDK default for jdk.jceks.iterationCount.
+	jceksIterations    = 200000
+	jceksMaxIterations = 5000000
+)
+
+var (
+	oidJKSKeyProtector           = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+	oidPBEWithMD5AndTripleDES    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 19, 1}
+	errJKSWrongPassword          = fmt.Errorf("%w: keystore was tampered with, or password was incorrect", ErrDecryption)
+	errJKSWrongKeyPassword       = fmt.Errorf("%w: cannot recover key, wrong key password", ErrDecryption)
+	errJKSTruncated              = fmt.Errorf("%w: truncated keystore", ErrInvalidFormat)
+	errJCEKSNonASCIIPassword     = errors.New("JCEKS passwords must be printable ASCII")
+	errJCEKSSecretKeyUnsupported = errors.New("JCEKS secret key entries are not supported")
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParameter struct {
+	Salt       []byte
+	Iterations int
+}
+
+// JKSEntry is a Java keystore entry. Private key entries have Key and its
+// certificate chain starting with the leaf. Trusted certificate entries have
+// a nil Key and one certificate in Chain.
+type JKSEntry struct {
+	// Alias is stored in lowercase like keytool does.
+	Alias string
+	// Created is the creation date. Zero uses the current time.
+	Created time.Time
+	Key     interface{}
+	Chain   []*x509.Certificate
+}
+
+// JKSTrustedEntries returns trusted certificate entries for certs. Aliases
+// are the lowercase common names with a "-n" suffix for duplicates.
+func JKSTrustedEntries(certs ...*x509.Certificate) []JKSEntry {
+	used := make(map[string]bool)
+	entries := make([]JKSEntry, 0, len(certs))
+	for _, c := range certs {
+		base := strings.ToLower(c.Subject.CommonName)
+		if base == "" {
+			base = "cert"
+		}
+		alias := base
+		for n := 2; used[alias]; n++ {
+			alias = fmt.Sprintf("%s-%d", base, n)
+		}
+		used[alias] = true
+		entries = append(entries, JKSEntry{Alias: alias, Chain: []*x509.Certificate{c}})
+	}
+	return entries
+}
+
+// EncodeJKS returns a keystore with entries. storePassword protects the
+// keystore integrity and keyPassword the private keys. If keyPassword is
+// empty, storePassword is used like keytool does.
+func EncodeJKS(entries []JKSEntry, typ JKSType, storePassword,
+	keyPassword string) ([]byte, error) {
+
+	if keyPassword == "" {
+		keyPassword = storePassword
+	}
+	var b bytes.Buffer
+	magic := uint32(jksMagic)
+	if typ == JCEKS {
+		magic = jceksMagic
+	}
+	writeUint32(&b, magic)
+	writeUint32(&b, jksVersion)
+	writeUint32(&b, uint32(len(entries)))
+
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		alias := strings.ToLower(e.Alias)
+		if alias == "" || seen[alias] {
+			return nil, fmt.Errorf("empty or duplicate alias %q", e.Alias)
+		}
+		seen[alias] = true
+		if len(e.Chain) == 0 {
+			return nil, fmt.Errorf("%s: entry has no certificates", alias)
+		}
+		created := e.Created
+		if created.IsZero() {
+			created = time.Now()
+		}
+
+		if e.Key == nil {
+			writeUint32(&b, jksCertTag)
+			writeJavaUTF(&b, alias)
+			writeUint64(&b, uint64(created.UnixNano()/int64(time.Millisecond)))
+			writeJKSCert(&b, e.Chain[0])
+			continue
+		}
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(e.Key)
+		if err != nil {
+			return nil, &KeyTypeError{Param: "key", Key: e.Key}
+		}
+		var protected []byte
+		if typ == JCEKS {
+			protected, err = jceksProtect(pkcs8, keyPassword)
+		} else {
+			protected, err = jksProtect(pkcs8, keyPassword)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", alias, err)
+		}
+		writeUint32(&b, jksKeyTag)
+		writeJavaUTF(&b, alias)
+		writeUint64(&b, uint64(created.UnixNano()/int64(time.Millisecond)))
+		writeUint32(&b, uint32(len(protected)))
+		b.Write(protected)
+		writeUint32(&b, uint32(len(e.Chain)))
+		for _, c := range e.Chain {
+			writeJKSCert(&b, c)
+		}
+	}
+	b.Write(jksDigest(b.Bytes(), storePassword))
+	return b.Bytes(), nil
+}
+
+// DecodeJKS parses a JKS or JCEKS keystore and checks its integrity with
+// storePassword. Private keys are decrypted with keyPassword or storePassword
+// if keyPassword is empty.
+func DecodeJKS(data []byte, storePassword, keyPassword string) ([]JKSEntry, JKSType, error) {
+	if keyPassword == "" {
+		keyPassword = storePassword
+	}
+	if len(data) < 12+sha1.Size {
+		return nil, 0, errJKSTruncated
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if subtle.ConstantTimeCompare(jksDigest(body, storePassword), sum) != 1 {
+		return nil, 0, errJKSWrongPassword
+	}
+
+	r := &jksReader{data: body}
+	var typ JKSType
+	switch r.uint32() {
+	case jksMagic:
+		typ = JKS
+	case jceksMagic:
+		typ = JCEKS
+	default:
+		return nil, 0, fmt.Errorf("invalid keystore format")
+	}
+	if v := r.uint32(); v != jksVersion {
+		return nil, 0, fmt.Errorf("unsupported keystore version, got %d", v)
+	}
+	count := r.uint32()
+	var entries []JKSEntry
+	for i := uint32(0); i < count && r.err == nil; i++ {
+		tag := r.uint32()
+		e := JKSEntry{Alias: r.javaUTF()}
+		e.Created = time.Unix(0, int64(r.uint64())*int64(time.Millisecond))
+		switch tag {
+		case jksCertTag:
+			cert, err := r.cert()
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", e.Alias, err)
+			}
+			e.Chain = []*x509.Certificate{cert}
+		case jksKeyTag:
+			protected := r.bytes(int(r.uint32()))
+			if r.err != nil {
+				break
+			}
+			key, err := recoverJKSKey(protected, keyPassword)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", e.Alias, err)
+			}
+			e.Key = key
+			n := r.uint32()
+			for j := uint32(0); j < n && r.err == nil; j++ {
+				cert, err := r.cert()
+				if err != nil {
+					return nil, 0, fmt.Errorf("%s: %w", e.Alias, err)
+				}
+				e.Chain = append(e.Chain, cert)
+			}
+		case jceksSecretTag:
+			return nil, 0, errJCEKSSecretKeyUnsupported
+		default:
+			return nil, 0, fmt.Errorf("unknown keystore entry tag, got %d", tag)
+		}
+		entries = append(entries, e)
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, 0, fmt.Errorf("trailing data in keystore")
+	}
+	return entries, typ, nil
+}
+
+// WriteJKSFile stores a keystore created by EncodeJKS in filename. The file
+// is not overwritten.
+func WriteJKSFile(filename string, entries []JKSEntry, typ JKSType, storePassword,
+	keyPassword string) error {
+
+	data, err := EncodeJKS(entries, typ, storePassword, keyPassword)
+	if err != nil {
+		return err
+	}
+	return writeFile(data, filename, false)
+}
+
+// ReadJKSFile reads a keystore file with DecodeJKS.
+func ReadJKSFile(filename, storePassword, keyPassword string) ([]JKSEntry, JKSType, error) {
+	data, err := filehelper.ReadFileByte(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	return DecodeJKS(data, storePassword, keyPassword)
+}
+
+// WriteJKS stores the certificates as a Java truststore in filename. The file
+// is not overwritten.
+func (s *TrustStore) WriteJKS(filename string, typ JKSType, password string) error {
+	return WriteJKSFile(filename, JKSTrustedEntries(s.certs...), typ, password, "")
+}
+
+// jksDigest returns the keystore integrity digest of data.
+func jksDigest(data []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(utf16BE(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// jksProtect encrypts a PKCS#8 key with the JKS key protector: the key is
+// XORed with a SHA-1 keystream of the password and a random salt.
+func jksProtect(pkcs8 []byte, password string) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	passwd := utf16BE(password)
+	protected := append([]byte(nil), salt...)
+	protected = append(protected, jksXOR(pkcs8, passwd, salt)...)
+	check := sha1.Sum(append(passwd, pkcs8...))
+	protected = append(protected, check[:]...)
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protected,
+	})
+}
+
+// jksXOR XORs data with the JKS keystream.
+func jksXOR(data, passwd, salt []byte) []byte {
+	out := make([]byte, len(data))
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte(nil), passwd...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			out[i+j] = data[i+j] ^ digest[j]
+		}
+	}
+	return out
+}
+
+// jceksProtect encrypts a PKCS#8 key with PBEWithMD5AndTripleDES.
+func jceksProtect(pkcs8 []byte, password string) ([]byte, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	block, iv, err := jceksCipher(password, salt, jceksIterations)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#5 padding.
+	pad := des.BlockSize - len(pkcs8)%des.BlockSize
+	padded := append(append([]byte(nil), pkcs8...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	params, err := asn1.Marshal(pbeParameter{Salt: salt, Iterations: jceksIterations})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBEWithMD5AndTripleDES,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: padded,
+	})
+}
+
+// jceksCipher derives the 3DES key and IV like com.sun.crypto.provider.PBES1Core.
+func jceksCipher(password string, salt []byte, iterations int) (cipher.Block, []byte, error) {
+	passwd := make([]byte, len(password))
+	for i := 0; i < len(password); i++ {
+		if password[i] < ' ' || password[i] > '~' {
+			return nil, nil, errJCEKSNonASCIIPassword
+		}
+		passwd[i] = password[i]
+	}
+	salt = append([]byte(nil), salt...)
+	if bytes.Equal(salt[:4], salt[4:]) {
+		// Java inverts the first half if both halves are equal. The JDK
+		// assigns salt[3-1] instead of salt[3-i], keep it compatible.
+		for i := 0; i < 2; i++ {
+			tmp := salt[i]
+			salt[i] = salt[3-i]
+			salt[2] = tmp
+		}
+	}
+	var derived []byte
+	for i := 0; i < 2; i++ {
+		digest := salt[i*4 : i*4+4]
+		for j := 0; j < iterations; j++ {
+			sum := md5.Sum(append(append([]byte(nil), digest...), passwd...))
+			digest = sum[:]
+		}
+		derived = append(derived, digest...)
+	}
+	block, err := des.NewTripleDESCipher(derived[:24])
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, derived[24:], nil
+}
+
+// recoverJKSKey decrypts a protected private key.
+func recoverJKSKey(protected []byte, password string) (interface{}, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(protected, &info); err != nil {
+		return nil, err
+	}
+	var pkcs8 []byte
+	switch {
+	case info.Algorithm.Algorithm.Equal(oidJKSKeyProtector):
+		data := info.EncryptedData
+		if len(data) < 2*sha1.Size {
+			return nil, errJKSTruncated
+		}
+		salt := data[:sha1.Size]
+		check := data[len(data)-sha1.Size:]
+		passwd := utf16BE(password)
+		pkcs8 = jksXOR(data[sha1.Size:len(data)-sha1.Size], passwd, salt)
+		sum := sha1.Sum(append(passwd, pkcs8...))
+		if subtle.ConstantTimeCompare(sum[:], check) != 1 {
+			return nil, errJKSWrongKeyPassword
+		}
+	case info.Algorithm.Algorithm.Equal(oidPBEWithMD5AndTripleDES):
+		var params pbeParameter
+		if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if len(params.Salt) != 8 || params.Iterations < 1 || params.Iterations > jceksMaxIterations {
+			return nil, fmt.Errorf("invalid PBE parameters")
+		}
+		block, iv, err := jceksCipher(password, params.Salt, params.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		data := info.EncryptedData
+		if len(data) == 0 || len(data)%des.BlockSize != 0 {
+			return nil, errJKSWrongKeyPassword
+		}
+		pkcs8 = make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(pkcs8, data)
+		pad := int(pkcs8[len(pkcs8)-1])
+		if pad < 1 || pad > des.BlockSize {
+			return nil, errJKSWrongKeyPassword
+		}
+		pkcs8 = pkcs8[:len(pkcs8)-pad]
+	default:
+		return nil, fmt.Errorf("%w: key protection %s", ErrUnsupportedAlgorithm, info.Algorithm.Algorithm)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(pkcs8)
+	if err != nil {
+		return nil, errJKSWrongKeyPassword
+	}
+	return key, nil
+}
+
+// utf16BE returns s as UTF-16BE bytes like Java's password encoding.
+func utf16BE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+func writeUint32(b *bytes.Buffer, v uint32) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+func writeUint64(b *bytes.Buffer, v uint64) {
+	binary.Write(b, binary.BigEndian, v)
+}
+
+// writeJavaUTF writes s in Java's modified UTF-8 with a length prefix like
+// DataOutputStream.writeUTF.
+func writeJavaUTF(b *bytes.Buffer, s string) {
+	var enc []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		switch {
+		case c >= 0x01 && c <= 0x7f:
+			enc = append(enc, byte(c))
+		case c <= 0x7ff:
+			enc = append(enc, byte(0xc0|c>>6), byte(0x80|c&0x3f))
+		default:
+			enc = append(enc, byte(0xe0|c>>12), byte(0x80|(c>>6)&0x3f), byte(0x80|c&0x3f))
+		}
+	}
+	binary.Write(b, binary.BigEndian, uint16(len(enc)))
+	b.Write(enc)
+}
+
+// writeJKSCert writes a certificate with its type.
+func writeJKSCert(b *bytes.Buffer, cert *x509.Certificate) {
+	writeJavaUTF(b, jksCertType)
+	writeUint32(b, uint32(len(cert.Raw)))
+	b.Write(cert.Raw)
+}
+
+// jksReader reads keystore fields. After the first error all reads return
+// zero values and err is set.
+type jksReader struct {
+	data []byte
+	err  error
+}
+
+func (r *jksReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data) {
+		r.err = errJKSTruncated
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *jksReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *jksReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// javaUTF reads a string written by writeJavaUTF.
+func (r *jksReader) javaUTF() string {
+	lb := r.bytes(2)
+	if lb == nil {
+		return ""
+	}
+	b := r.bytes(int(binary.BigEndian.Uint16(lb)))
+	var units []uint16
+	for i := 0; i < len(b); {
+		switch {
+		case b[i]&0x80 == 0:
+			units = append(units, uint16(b[i]))
+			i++
+		case b[i]&0xe0 == 0xc0 && i+1 < len(b):
+			units = append(units, uint16(b[i]&0x1f)<<6|uint16(b[i+1]&0x3f))
+			i += 2
+		case b[i]&0xf0 == 0xe0 && i+2 < len(b):
+			units = append(units, uint16(b[i]&0x0f)<<12|uint16(b[i+1]&0x3f)<<6|uint16(b[i+2]&0x3f))
+			i += 3
+		default:
+			r.err = fmt.Errorf("invalid modified UTF-8 string")
+			return ""
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// cert reads a certificate written by writeJKSCert.
+func (r *jksReader) cert() (*x509.Certificate, error) {
+	if t := r.javaUTF(); r.err == nil && t != jksCertType {
+		return nil, fmt.Errorf("unsupported certificate type, got %s", t)
+	}
+	der := r.bytes(int(r.uint32()))
+	if r.err != nil {
+		return nil, r.err
+	}
+	return x509.ParseCertificate(der)
+}
@@ -0,0 +1,163 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJKS(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %s", err.Error())
+	}
+	rsaCert, err := CustomLeafCertWithKey("Server", "org1", "2", "US", 1, rsaKey, caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating RSA leaf: %s", err.Error())
+	}
+	ecCert, ecKey, err := ECLeafCert("client", "org1", "3", "US", "P384", caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating EC leaf: %s", err.Error())
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []JKSEntry{
+		{Alias: "Server", Created: created, Key: rsaKey, Chain: []*x509.Certificate{rsaCert, caCert}},
+		{Alias: "client", Created: created, Key: ecKey, Chain: []*x509.Certificate{ecCert, caCert}},
+	}
+	entries = append(entries, JKSTrustedEntries(caCert)...)
+
+	for _, typ := range []JKSType{JKS, JCEKS} {
+		data, err := EncodeJKS(entries, typ, "changeit", "keypass")
+		if err != nil {
+			t.Fatalf("%s: EncodeJKS error: %s", typ, err.Error())
+		}
+		got, gotType, err := DecodeJKS(data, "changeit", "keypass")
+		if err != nil {
+			t.Fatalf("%s: DecodeJKS error: %s", typ, err.Error())
+		}
+		if gotType != typ {
+			t.Errorf("%s: bad keystore type, got %s", typ, gotType)
+		}
+		if len(got) != len(entries) {
+			t.Fatalf("%s: bad number of entries, got %d", typ, len(got))
+		}
+		for i, e := range got {
+			want := entries[i]
+			if i < 2 && !e.Created.Equal(created) {
+				t.Errorf("%s: bad creation date for %s, got %s", typ, e.Alias, e.Created)
+			}
+			if len(e.Chain) != len(want.Chain) {
+				t.Fatalf("%s: bad chain length for %s, got %d", typ, e.Alias, len(e.Chain))
+			}
+			for j := range e.Chain {
+				if !e.Chain[j].Equal(want.Chain[j]) {
+					t.Errorf("%s: bad certificate %d for %s", typ, j, e.Alias)
+				}
+			}
+			if want.Key == nil {
+				if e.Key != nil {
+					t.Errorf("%s: trusted entry %s has a key", typ, e.Alias)
+				}
+				continue
+			}
+			gotDER, _ := x509.MarshalPKCS8PrivateKey(e.Key)
+			wantDER, _ := x509.MarshalPKCS8PrivateKey(want.Key)
+			if !bytes.Equal(gotDER, wantDER) {
+				t.Errorf("%s: bad key for %s", typ, e.Alias)
+			}
+		}
+		if got[0].Alias != "server" || got[2].Alias != "root1" {
+			t.Errorf("%s: bad aliases, got %q and %q", typ, got[0].Alias, got[2].Alias)
+		}
+
+		if _, _, err := DecodeJKS(data, "wrong", "keypass"); !errors.Is(err, ErrDecryption) {
+			t.Errorf("%s: bad error for the wrong store password, got %v", typ, err)
+		}
+		if _, _, err := DecodeJKS(data, "changeit", "wrong"); !errors.Is(err, ErrDecryption) {
+			t.Errorf("%s: bad error for the wrong key password, got %v", typ, err)
+		}
+		if _, _, err := DecodeJKS(data[:20], "changeit", "keypass"); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%s: bad error for a truncated keystore, got %v", typ, err)
+		}
+		tampered := append([]byte(nil), data...)
+		tampered[len(tampered)/2] ^= 1
+		if _, _, err := DecodeJKS(tampered, "changeit", "keypass"); err == nil {
+			t.Errorf("%s: decoded a tampered keystore", typ)
+		}
+	}
+
+	// keyPassword defaults to storePassword.
+	data, err := EncodeJKS(entries[:1], JKS, "changeit", "")
+	if err != nil {
+		t.Fatalf("EncodeJKS error: %s", err.Error())
+	}
+	if _, _, err := DecodeJKS(data, "changeit", ""); err != nil {
+		t.Errorf("DecodeJKS error: %s", err.Error())
+	}
+
+	dup := []JKSEntry{entries[0], entries[0]}
+	dup[1].Alias = "SERVER"
+	if _, err := EncodeJKS(dup, JKS, "changeit", ""); err == nil {
+		t.Errorf("encoded duplicate aliases")
+	}
+	if _, err := EncodeJKS(entries[:1], JCEKS, "chängeit", ""); err == nil {
+		t.Errorf("encoded JCEKS key with a non-ASCII password")
+	}
+}
+
+func TestJKSTrustedEntries(t *testing.T) {
+	root1, _, err := ECRootCA("Root", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	root2, _, err := ECRootCA("root", "org1", "2", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	entries := JKSTrustedEntries(root1, root2, root1)
+	for i, want := range []string{"root", "root-2", "root-3"} {
+		if entries[i].Alias != want {
+			t.Errorf("bad alias %d, got %q, want %q", i, entries[i].Alias, want)
+		}
+	}
+}
+
+func TestTrustStoreWriteJKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, _, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	s := NewTrustStore()
+	s.AddCert(root)
+	file := filepath.Join(dir, "truststore.jks")
+	if err := s.WriteJKS(file, JKS, "changeit"); err != nil {
+		t.Fatalf("WriteJKS error: %s", err.Error())
+	}
+	entries, typ, err := ReadJKSFile(file, "changeit", "")
+	if err != nil {
+		t.Fatalf("ReadJKSFile error: %s", err.Error())
+	}
+	if typ != JKS || len(entries) != 1 || !entries[0].Chain[0].Equal(root) {
+		t.Errorf("bad truststore, got %s with %d entries", typ, len(entries))
+	}
+	if err := s.WriteJKS(file, JKS, "changeit"); !errors.Is(err, ErrFileExists) {
+		t.Errorf("WriteJKS overwrote the file, got %v", err)
+	}
+}
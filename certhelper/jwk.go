@@ -0,0 +1,300 @@
+package certhelper
+
+// JSON Web Key (RFC 7517) helpers.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public JSON Web Key. Only the members used by RSA, EC and OKP
+// (Ed25519) keys are supported.
+type JWK struct {
+	Kty     string   `json:"kty"`
+	Kid     string   `json:"kid,omitempty"`
+	Use     string   `json:"use,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5t     string   `json:"x5t,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeyToJWK converts the public half of an RSA, EC or Ed25519 key to a JWK.
+// key can be a public or private key. kid is set to the RFC 7638 thumbprint.
+func KeyToJWK(key interface{}) (*JWK, error) {
+	if s, ok := key.(crypto.Signer); ok {
+		key = s.Public()
+	}
+	var jwk JWK
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.Alg = "RS256"
+		jwk.N = b64(k.N.Bytes())
+		jwk.E = b64(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		switch k.Curve {
+		case elliptic.P256():
+			jwk.Crv, jwk.Alg = "P-256", "ES256"
+		case elliptic.P384():
+			jwk.Crv, jwk.Alg = "P-384", "ES384"
+		case elliptic.P521():
+			jwk.Crv, jwk.Alg = "P-521", "ES512"
+		default:
+			return nil, fmt.Errorf("%w, got %s", ErrUnknownCurve, k.Curve.Params().Name)
+		}
+		// Coordinates must be padded to the curve size.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.X = b64(padBytes(k.X.Bytes(), size))
+		jwk.Y = b64(padBytes(k.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.Alg = "EdDSA"
+		jwk.X = b64(k)
+	default:
+		return nil, &KeyTypeError{Param: "public key", Key: k}
+	}
+	kid, err := JWKThumbprint(&jwk)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = kid
+	return &jwk, nil
+}
+
+// CertToJWK converts the public key of cert to a JWK and populates x5c, x5t
+// and x5t#S256. chain is added to x5c after cert and can be empty.
+func CertToJWK(cert *x509.Certificate, chain ...*x509.Certificate) (*JWK, error) {
+	jwk, err := KeyToJWK(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	for _, c := range chain {
+		jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+	s1 := sha1.Sum(cert.Raw)
+	s256 := sha256.Sum256(cert.Raw)
+	jwk.X5t = b64(s1[:])
+	jwk.X5tS256 = b64(s256[:])
+	return jwk, nil
+}
+
+// JWKThumbprint returns the base64url encoded RFC 7638 SHA-256 thumbprint of
+// jwk.
+func JWKThumbprint(jwk *JWK) (string, error) {
+	// Only the required members in lexicographic order. Values are base64url
+	// or fixed names so they do not need escaping.
+	var s string
+	switch jwk.Kty {
+	case "RSA":
+		s = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		s = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		s = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	default:
+		return "", fmt.Errorf("%w: unknown JWK kty, got %s", ErrUnsupportedAlgorithm, jwk.Kty)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return b64(sum[:]), nil
+}
+
+// PublicKey returns the public key in jwk. The result is an *rsa.PublicKey,
+// *ecdsa.PublicKey or ed25519.PublicKey.
+func (jwk *JWK) PublicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := unb64(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := unb64(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		eInt := new(big.Int).SetBytes(e)
+		if !eInt.IsInt64() || eInt.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(eInt.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w, got %s", ErrUnknownCurve, jwk.Crv)
+		}
+		x, err := unb64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := unb64(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC point is not on curve %s", jwk.Crv)
+		}
+		return pub, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w, got %s", ErrUnknownCurve, jwk.Crv)
+		}
+		x, err := unb64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length, got %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown JWK kty, got %s", ErrUnsupportedAlgorithm, jwk.Kty)
+	}
+}
+
+// Certificates parses and returns the certificates in jwk's x5c.
+func (jwk *JWK) Certificates() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, c := range jwk.X5c {
+		// x5c uses standard base64, not base64url.
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// Key returns the key with kid from the set or nil if it does not exist.
+func (jwks *JWKS) Key(kid string) *JWK {
+	for i := range jwks.Keys {
+		if jwks.Keys[i].Kid == kid {
+			return &jwks.Keys[i]
+		}
+	}
+	return nil
+}
+
+// KeysToJWKS converts a list of keys to a JWKS. Each item can be a public key,
+// a private key or an *x509.Certificate.
+func KeysToJWKS(keys ...interface{}) (*JWKS, error) {
+	var jwks JWKS
+	for _, k := range keys {
+		var jwk *JWK
+		var err error
+		if cert, ok := k.(*x509.Certificate); ok {
+			jwk, err = CertToJWK(cert)
+		} else {
+			jwk, err = KeyToJWK(k)
+		}
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	return &jwks, nil
+}
+
+// KeyToJWKJSON converts a key to a JWK and returns its JSON.
+func KeyToJWKJSON(key interface{}) ([]byte, error) {
+	jwk, err := KeyToJWK(key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jwk)
+}
+
+// KeyToJWKFile converts a key to a JWK and stores its JSON in a file.
+func KeyToJWKFile(key interface{}, filename string) error {
+	j, err := KeyToJWKJSON(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(j, filename, false)
+}
+
+// JWKSToFile stores the JSON of jwks in a file.
+func JWKSToFile(jwks *JWKS, filename string) error {
+	j, err := json.Marshal(jwks)
+	if err != nil {
+		return err
+	}
+	return writeFile(j, filename, false)
+}
+
+// ParseJWK parses a JWK from JSON.
+func ParseJWK(data []byte) (*JWK, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, err
+	}
+	return &jwk, nil
+}
+
+// ParseJWKS parses a JWKS from JSON.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	return &jwks, nil
+}
+
+// b64 returns the unpadded base64url encoding of b.
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// unb64 decodes an unpadded base64url string.
+func unb64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// padBytes left-pads b with zeros to size bytes.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
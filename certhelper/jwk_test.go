@@ -0,0 +1,77 @@
+package certhelper
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"testing"
+)
+
+// Example from RFC 7638 section 3.1.
+func TestJWKThumbprint(t *testing.T) {
+	jwk := JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+	got, err := JWKThumbprint(&jwk)
+	if err != nil {
+		t.Fatalf("error in JWKThumbprint: %s", err.Error())
+	}
+	if got != want {
+		t.Errorf("JWKThumbprint error: got %s, want %s", got, want)
+	}
+}
+
+func TestKeysToJWKS(t *testing.T) {
+	caCert, caKey, err := ECRootCA("root1", "org1", "1234", "US", "P384")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error creating RSA key: %s", err.Error())
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating Ed25519 key: %s", err.Error())
+	}
+
+	jwks, err := KeysToJWKS(caCert, rsaKey, edPub)
+	if err != nil {
+		t.Fatalf("error in KeysToJWKS: %s", err.Error())
+	}
+	b, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("error marshaling JWKS: %s", err.Error())
+	}
+	parsed, err := ParseJWKS(b)
+	if err != nil {
+		t.Fatalf("error in ParseJWKS: %s", err.Error())
+	}
+
+	wants := []interface{}{caKey.Public(), rsaKey.Public(), edPub}
+	for i, want := range wants {
+		jwk := parsed.Key(jwks.Keys[i].Kid)
+		if jwk == nil {
+			t.Errorf("key %d: kid %s not found", i, jwks.Keys[i].Kid)
+			continue
+		}
+		got, err := jwk.PublicKey()
+		if err != nil {
+			t.Errorf("key %d: error in PublicKey: %s", i, err.Error())
+			continue
+		}
+		if !want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Errorf("key %d: public key mismatch", i)
+		}
+	}
+	// The certificate should round-trip through x5c.
+	certs, err := parsed.Keys[0].Certificates()
+	if err != nil || len(certs) != 1 || !certs[0].Equal(caCert) {
+		t.Errorf("x5c error: got %v, %v", certs, err)
+	}
+}
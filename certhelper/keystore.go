@@ -0,0 +1,486 @@
+package certhelper
+
+// Key stores that sign with keys by label, like an HSM.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KeySpec describes a key to generate.
+type KeySpec struct {
+	// Algo is "RSA" or "EC" (case-insensitive).
+	Algo string
+	// KeySize is the RSA key size. Default is 2048.
+	KeySize int
+	// Curve is the EC curve. Default is CurveP256.
+	Curve Curve
+}
+
+// KeyStore keeps private keys by label and signs with them without returning
+// the key material. Implementations must be safe for concurrent use.
+// Use KeyHandle to pass a key to functions that need a crypto.Signer, e.g.,
+// CustomRootCAWithKey and CustomLeafCertWithKey, or create certificates with
+// KeyStoreRootCA and KeyStoreLeafCert.
+type KeyStore interface {
+	// Generate creates a key with label and returns its public key. Returns
+	// ErrKeyExists if label is used.
+	Generate(label string, spec KeySpec) (crypto.PublicKey, error)
+	// Public returns the public key of label.
+	Public(label string) (crypto.PublicKey, error)
+	// Sign signs digest with label. See crypto.Signer.
+	Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error)
+	// Delete removes label.
+	Delete(label string) error
+}
+
+// KeyHandle is a crypto.Signer for a key in a KeyStore.
+type KeyHandle struct {
+	Store KeyStore
+	Label string
+	pub   crypto.PublicKey
+}
+
+// NewKeyHandle returns a handle for an existing key in store.
+func NewKeyHandle(store KeyStore, label string) (*KeyHandle, error) {
+	pub, err := store.Public(label)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyHandle{Store: store, Label: label, pub: pub}, nil
+}
+
+// GenerateKeyHandle generates a key in store and returns its handle.
+func GenerateKeyHandle(store KeyStore, label string, spec KeySpec) (*KeyHandle, error) {
+	pub, err := store.Generate(label, spec)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyHandle{Store: store, Label: label, pub: pub}, nil
+}
+
+// KeyStoreRootCA generates a key with label in store and returns a custom
+// self-signed root CA for it and the key's handle. The key is deleted if the
+// certificate cannot be created. See CustomRootCAWithKey.
+func KeyStoreRootCA(store KeyStore, label string, spec KeySpec,
+	commonName, orgUnit, serialNumber, countryCode string, validity,
+	maxPathLen int, keyUsage x509.KeyUsage,
+	opts ...TemplateOption) (*x509.Certificate, *KeyHandle, error) {
+
+	handle, err := GenerateKeyHandle(store, label, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := CustomRootCAWithKey(commonName, orgUnit, serialNumber, countryCode,
+		validity, maxPathLen, keyUsage, handle, opts...)
+	if err != nil {
+		store.Delete(label)
+		return nil, nil, err
+	}
+	return cert, handle, nil
+}
+
+// KeyStoreLeafCert generates a key with label in store and returns a custom
+// leaf certificate for it signed by caCert with caPrivKey, and the key's
+// handle. caPrivKey can also be a KeyHandle. The key is deleted if the
+// certificate cannot be created. See CustomLeafCertWithKey.
+func KeyStoreLeafCert(store KeyStore, label string, spec KeySpec,
+	commonName, orgUnit, serialNumber, countryCode string, validity int,
+	caCert *x509.Certificate, caPrivKey interface{},
+	opts ...TemplateOption) (*x509.Certificate, *KeyHandle, error) {
+
+	handle, err := GenerateKeyHandle(store, label, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := CustomLeafCertWithKey(commonName, orgUnit, serialNumber, countryCode,
+		validity, handle, caCert, caPrivKey, opts...)
+	if err != nil {
+		store.Delete(label)
+		return nil, nil, err
+	}
+	return cert, handle, nil
+}
+
+// Public returns the public key.
+func (h *KeyHandle) Public() crypto.PublicKey {
+	return h.pub
+}
+
+// Sign signs digest with the key in the store. rand is ignored, the store
+// uses crypto/rand.
+func (h *KeyHandle) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return h.Store.Sign(h.Label, digest, opts)
+}
+
+// MemoryKeyStore is a KeyStore that keeps keys in memory.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+// NewMemoryKeyStore returns an empty MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]crypto.Signer)}
+}
+
+// Generate creates a key with label.
+func (s *MemoryKeyStore) Generate(label string, spec KeySpec) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[label]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, label)
+	}
+	key, err := generateSpecKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	s.keys[label] = key
+	return key.Public(), nil
+}
+
+// Public returns the public key of label.
+func (s *MemoryKeyStore) Public(label string) (crypto.PublicKey, error) {
+	key, err := s.key(label)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Sign signs digest with label.
+func (s *MemoryKeyStore) Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := s.key(label)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// Delete removes label.
+func (s *MemoryKeyStore) Delete(label string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[label]; !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+	}
+	delete(s.keys, label)
+	return nil
+}
+
+func (s *MemoryKeyStore) key(label string) (crypto.Signer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[label]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+	}
+	return key, nil
+}
+
+// FileKeyStore is a KeyStore that stores each key in "<dir>/<label>.key" as
+// a PKCS#8 "ENCRYPTED PRIVATE KEY" PEM file (PBES2 with PBKDF2-SHA256 and
+// AES-256-CBC). The files can be read with
+// 	openssl pkey -in label.key -passin pass:password
+// Keys are decrypted for every operation and not kept in memory.
+type FileKeyStore struct {
+	dir      string
+	password string
+	mu       sync.Mutex
+}
+
+// Label format for FileKeyStore, labels are used as file names.
+var fileKeyLabel = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// NewFileKeyStore returns a FileKeyStore in dir that encrypts keys with
+// password. dir is created if it does not exist.
+func NewFileKeyStore(dir, password string) (*FileKeyStore, error) {
+	if password == "" {
+		return nil, fmt.Errorf("empty key store password")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileKeyStore{dir: dir, password: password}, nil
+}
+
+// Generate creates a key with label. label can contain letters, digits, ".",
+// "_" and "-" and can not start with ".".
+func (s *FileKeyStore) Generate(label string, spec KeySpec) (crypto.PublicKey, error) {
+	file, err := s.path(label)
+	if err != nil {
+		return nil, err
+	}
+	key, err := generateSpecKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptPKCS8(der, s.password)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(file)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Public returns the public key of label.
+func (s *FileKeyStore) Public(label string) (crypto.PublicKey, error) {
+	key, err := s.key(label)
+	if err != nil {
+		return nil, err
+	}
+	return key.Public(), nil
+}
+
+// Sign signs digest with label.
+func (s *FileKeyStore) Sign(label string, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := s.key(label)
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, digest, opts)
+}
+
+// Delete removes the key file of label.
+func (s *FileKeyStore) Delete(label string) error {
+	file, err := s.path(label)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(file); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+		}
+		return err
+	}
+	return nil
+}
+
+// path returns the key file of label.
+func (s *FileKeyStore) path(label string) (string, error) {
+	if !fileKeyLabel.MatchString(label) {
+		return "", fmt.Errorf("invalid key label %q", label)
+	}
+	return filepath.Join(s.dir, label+".key"), nil
+}
+
+// key reads and decrypts the key of label.
+func (s *FileKeyStore) key(label string) (crypto.Signer, error) {
+	file, err := s.path(label)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	data, err := ioutil.ReadFile(file)
+	s.mu.Unlock()
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: invalid key file", label)
+	}
+	der, err := decryptPKCS8(block.Bytes, s.password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", label, err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// The padding can be valid by chance with the wrong password.
+		return nil, fmt.Errorf("%s: %w: wrong password", label, ErrDecryption)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: label, Key: key}
+	}
+	return signer, nil
+}
+
+// generateSpecKey generates a key for spec.
+func generateSpecKey(spec KeySpec) (crypto.Signer, error) {
+	switch strings.ToUpper(spec.Algo) {
+	case "RSA":
+		size := spec.KeySize
+		if size == 0 {
+			size = 2048
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	case "EC":
+		curve := spec.Curve
+		if curve == 0 {
+			curve = CurveP256
+		}
+		return GenerateECKey(curve)
+	default:
+		return nil, fmt.Errorf("%w: algo must be EC or RSA, got %s", ErrUnsupportedAlgorithm, spec.Algo)
+	}
+}
+
+// PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC from RFC 8018.
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+const (
+	pbkdf2Iterations = 100000
+	// maxPBKDF2Iterations limits the work a key file can ask for.
+	maxPBKDF2Iterations = 10000000
+)
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// encryptPKCS8 returns a DER EncryptedPrivateKeyInfo of the PKCS#8 key der.
+func encryptPKCS8(der []byte, password string) ([]byte, error) {
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(append([]byte(nil), der...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pbkdf2Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+// decryptPKCS8 decrypts an EncryptedPrivateKeyInfo from encryptPKCS8.
+func decryptPKCS8(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	var params pbes2Params
+	var kdf pbkdf2Params
+	var iv []byte
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("%w: key encryption %s", ErrUnsupportedAlgorithm, info.Algorithm.Algorithm)
+	}
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) ||
+		!params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, fmt.Errorf("%w: unsupported PBES2 parameters", ErrUnsupportedAlgorithm)
+	}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	if !kdf.PRF.Algorithm.Equal(oidHMACWithSHA256) {
+		return nil, fmt.Errorf("%w: PBKDF2 PRF %s", ErrUnsupportedAlgorithm, kdf.PRF.Algorithm)
+	}
+	if kdf.Iterations < 1 || kdf.Iterations > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("%w: PBKDF2 iteration count %d", ErrDecryption, kdf.Iterations)
+	}
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrDecryption
+	}
+	key := pbkdf2.Key([]byte(password), kdf.Salt, kdf.Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad < 1 || pad > aes.BlockSize ||
+		subtle.ConstantTimeCompare(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) != 1 {
+		return nil, fmt.Errorf("%w: wrong password", ErrDecryption)
+	}
+	return out[:len(out)-pad], nil
+}
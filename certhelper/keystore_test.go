@@ -0,0 +1,134 @@
+package certhelper
+
+import (
+	"crypto/aes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testKeyStore issues a chain with keys in store and checks the errors.
+func testKeyStore(t *testing.T, store KeyStore) {
+	caCert, caKey, err := KeyStoreRootCA(store, "root", KeySpec{Algo: "EC", Curve: CurveP384},
+		"root1", "org1", "1", "US", 1, 0, CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, leafKey, err := KeyStoreLeafCert(store, "leaf", KeySpec{Algo: "RSA"},
+		"leaf1", "org1", "2", "US", 1, caCert, caKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	if _, err := VerifyChain(leaf, nil, []*x509.Certificate{caCert}); err != nil {
+		t.Errorf("error verifying leaf: %s", err.Error())
+	}
+	if ok, err := KeyMatchesCert(leaf, leafKey); err != nil || !ok {
+		t.Errorf("leaf key does not match the certificate, got %v", err)
+	}
+
+	// Handles for existing keys.
+	handle, err := NewKeyHandle(store, "leaf")
+	if err != nil {
+		t.Fatalf("NewKeyHandle error: %s", err.Error())
+	}
+	if ok, _ := KeyMatchesCert(leaf, handle); !ok {
+		t.Errorf("handle does not match the certificate")
+	}
+
+	// Keys are deleted if the certificate cannot be created.
+	if _, _, err := KeyStoreLeafCert(store, "bad", KeySpec{Algo: "EC"},
+		"leaf2", "org1", "3", "US", 1, caCert, "not a key"); err == nil {
+		t.Errorf("created a leaf with an invalid CA key")
+	}
+	if _, err := store.Public("bad"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key of a failed leaf was kept, got %v", err)
+	}
+
+	if _, err := store.Generate("leaf", KeySpec{Algo: "EC"}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("generated a key with an existing label, got %v", err)
+	}
+	if _, err := store.Generate("other", KeySpec{Algo: "DSA"}); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("generated a DSA key, got %v", err)
+	}
+	if err := store.Delete("leaf"); err != nil {
+		t.Errorf("Delete error: %s", err.Error())
+	}
+	if _, err := store.Public("leaf"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Public after Delete, got %v", err)
+	}
+	if _, err := store.Sign("leaf", make([]byte, 32), nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Sign after Delete, got %v", err)
+	}
+	if err := store.Delete("leaf"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Delete twice, got %v", err)
+	}
+}
+
+func TestMemoryKeyStore(t *testing.T) {
+	testKeyStore(t, NewMemoryKeyStore())
+}
+
+func TestFileKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileKeyStore(filepath.Join(dir, "keys"), "password")
+	if err != nil {
+		t.Fatalf("NewFileKeyStore error: %s", err.Error())
+	}
+	testKeyStore(t, store)
+
+	info, err := os.Stat(filepath.Join(dir, "keys", "root.key"))
+	if err != nil {
+		t.Fatalf("key file error: %s", err.Error())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("bad key file permissions, got %s", info.Mode())
+	}
+	if _, err := store.Generate("../root", KeySpec{Algo: "EC"}); err == nil {
+		t.Errorf("generated a key outside the store")
+	}
+
+	wrong, err := NewFileKeyStore(filepath.Join(dir, "keys"), "wrong")
+	if err != nil {
+		t.Fatalf("NewFileKeyStore error: %s", err.Error())
+	}
+	if _, err := wrong.Public("root"); !errors.Is(err, ErrDecryption) {
+		t.Errorf("read a key with the wrong password, got %v", err)
+	}
+}
+
+func TestDecryptPKCS8Iterations(t *testing.T) {
+	for _, iterations := range []int{0, maxPBKDF2Iterations + 1} {
+		kdf, _ := asn1.Marshal(pbkdf2Params{
+			Salt:       make([]byte, 16),
+			Iterations: iterations,
+			PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+		iv, _ := asn1.Marshal(make([]byte, aes.BlockSize))
+		params, _ := asn1.Marshal(pbes2Params{
+			KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2,
+				Parameters: asn1.RawValue{FullBytes: kdf}},
+			EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC,
+				Parameters: asn1.RawValue{FullBytes: iv}},
+		})
+		der, err := asn1.Marshal(encryptedPrivateKeyInfo{
+			Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedData: make([]byte, aes.BlockSize),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decryptPKCS8(der, "password"); !errors.Is(err, ErrDecryption) {
+			t.Errorf("decrypted with %d iterations, got %v", iterations, err)
+		}
+	}
+}
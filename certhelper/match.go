@@ -0,0 +1,167 @@
+package certhelper
+
+// Certificate and key matching.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// CertKeyPair is a certificate and its private key.
+type CertKeyPair struct {
+	Cert *x509.Certificate
+	Key  interface{}
+}
+
+// KeyMatchesCert returns true if privKey is the private key for cert's public
+// key. privKey can be an RSA, EC or Ed25519 private key.
+func KeyMatchesCert(cert *x509.Certificate, privKey interface{}) (bool, error) {
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return false, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false, &KeyTypeError{Param: "public key", Key: signer.Public()}
+	}
+	return pub.Equal(cert.PublicKey), nil
+}
+
+// IssuedBy returns true if cert was signed by issuer. The issuer name, key
+// identifiers (if present) and signature are checked.
+func IssuedBy(cert, issuer *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, issuer.RawSubject) {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 &&
+		!bytes.Equal(cert.AuthorityKeyId, issuer.SubjectKeyId) {
+		return false
+	}
+	return cert.CheckSignatureFrom(issuer) == nil
+}
+
+// MatchKeys pairs certificates with their private keys. Returns the pairs in
+// the order of certs and the keys that do not match any certificate.
+// Certificates without a key are not returned.
+func MatchKeys(certs []*x509.Certificate, keys []interface{}) (pairs []CertKeyPair,
+	orphans []interface{}) {
+
+	used := make([]bool, len(keys))
+	for _, c := range certs {
+		for i, k := range keys {
+			if ok, _ := KeyMatchesCert(c, k); ok {
+				pairs = append(pairs, CertKeyPair{Cert: c, Key: k})
+				used[i] = true
+				break
+			}
+		}
+	}
+	for i, k := range keys {
+		if !used[i] {
+			orphans = append(orphans, k)
+		}
+	}
+	return pairs, orphans
+}
+
+// BuildChains groups certs into chains. Each chain starts with a certificate
+// that did not issue any other certificate in certs and follows its issuers
+// until a self-signed certificate or a missing issuer.
+func BuildChains(certs []*x509.Certificate) [][]*x509.Certificate {
+	isIssuer := make([]bool, len(certs))
+	for i, c := range certs {
+		for j, issuer := range certs {
+			if i != j && IssuedBy(c, issuer) {
+				isIssuer[j] = true
+			}
+		}
+	}
+	var chains [][]*x509.Certificate
+	for i, c := range certs {
+		if isIssuer[i] {
+			continue
+		}
+		chain := []*x509.Certificate{c}
+		for {
+			next := findIssuer(chain[len(chain)-1], certs, chain)
+			if next == nil {
+				break
+			}
+			chain = append(chain, next)
+		}
+		chains = append(chains, chain)
+	}
+	return chains
+}
+
+// ParsePEMFiles reads certificates and private keys from PEM files. Supported
+// key blocks are "RSA PRIVATE KEY", "EC PRIVATE KEY" and "PRIVATE KEY".
+// Other blocks are ignored.
+func ParsePEMFiles(filenames ...string) (certs []*x509.Certificate,
+	keys []interface{}, err error) {
+
+	for _, f := range filenames {
+		data, err := filehelper.ReadFileByte(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			switch block.Type {
+			case "CERTIFICATE":
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", f, err)
+				}
+				certs = append(certs, c)
+			case "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY":
+				k, err := parseKeyBlock(block)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", f, err)
+				}
+				keys = append(keys, k)
+			}
+		}
+	}
+	return certs, keys, nil
+}
+
+// findIssuer returns the issuer of cert from certs that is not already in
+// chain.
+func findIssuer(cert *x509.Certificate, certs, chain []*x509.Certificate) *x509.Certificate {
+next:
+	for _, issuer := range certs {
+		for _, c := range chain {
+			if c == issuer {
+				continue next
+			}
+		}
+		if IssuedBy(cert, issuer) {
+			return issuer
+		}
+	}
+	return nil
+}
+
+// parseKeyBlock parses a private key PEM block.
+func parseKeyBlock(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unknown private key block type, got %s", block.Type)
+	}
+}
@@ -0,0 +1,95 @@
+package certhelper
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, rootKey, err := CustomECRootCA("root1", "org1", "1", "US", "P256",
+		1, 1, CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	// Create an intermediate by cross-signing a second CA with the root.
+	selfSigned, interKey, err := ECRootCA("inter1", "org1", "2", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating intermediate CA: %s", err.Error())
+	}
+	inter, err := CrossSign(selfSigned, root, rootKey, "3", 1)
+	if err != nil {
+		t.Fatalf("error in CrossSign: %s", err.Error())
+	}
+	leaf, leafKey, err := ECLeafCert("leaf1", "org1", "4", "US", "P256", inter, interKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	orphan, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error creating key: %s", err.Error())
+	}
+
+	// Write everything to files in random order.
+	files := map[string]interface{}{
+		"a.pem": leaf, "b.pem": orphan, "c.pem": root,
+		"d.pem": rootKey, "e.pem": inter, "f.pem": leafKey,
+	}
+	var names []string
+	for name, v := range files {
+		name = filepath.Join(dir, name)
+		names = append(names, name)
+		if c, ok := v.(*x509.Certificate); ok {
+			err = CertToPEMFile(c, name)
+		} else {
+			err = KeyToPEMFile(v, name)
+		}
+		if err != nil {
+			t.Fatalf("error writing %s: %s", name, err.Error())
+		}
+	}
+
+	certs, keys, err := ParsePEMFiles(names...)
+	if err != nil {
+		t.Fatalf("ParsePEMFiles error: %s", err.Error())
+	}
+	if len(certs) != 3 || len(keys) != 3 {
+		t.Fatalf("ParsePEMFiles returned %d certificates and %d keys", len(certs), len(keys))
+	}
+
+	pairs, orphans := MatchKeys(certs, keys)
+	if len(pairs) != 2 || len(orphans) != 1 {
+		t.Fatalf("MatchKeys returned %d pairs and %d orphans", len(pairs), len(orphans))
+	}
+	if ok, _ := KeyMatchesCert(leaf, orphans[0]); ok {
+		t.Errorf("orphan key matches the leaf")
+	}
+	for _, p := range pairs {
+		if !p.Cert.Equal(leaf) && !p.Cert.Equal(root) {
+			t.Errorf("unexpected pair for %s", p.Cert.Subject.CommonName)
+		}
+	}
+
+	chains := BuildChains(certs)
+	if len(chains) != 1 {
+		t.Fatalf("BuildChains returned %d chains, want 1", len(chains))
+	}
+	want := []*x509.Certificate{leaf, inter, root}
+	if len(chains[0]) != len(want) {
+		t.Fatalf("got chain length %d, want %d", len(chains[0]), len(want))
+	}
+	for i := range want {
+		if !chains[0][i].Equal(want[i]) {
+			t.Errorf("chain[%d] is %s, want %s", i,
+				chains[0][i].Subject.CommonName, want[i].Subject.CommonName)
+		}
+	}
+}
@@ -5,9 +5,6 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
-	"fmt"
-
-	"github.com/parsiya/go-utils/filehelper"
 )
 
 // CertDERToPEM converts a DER certificate to PEM.
@@ -18,7 +15,7 @@ func CertDERToPEM(certDER []byte) (certPEM []byte, err error) {
 	}
 	certPEM = pem.EncodeToMemory(pemBlock)
 	if certPEM == nil {
-		return nil, fmt.Errorf("PEM encoding failed")
+		return nil, &EncodingError{Format: "PEM"}
 	}
 	return certPEM, nil
 }
@@ -31,7 +28,7 @@ func CertDERToPEMFile(certDER []byte, filename string) error {
 		return err
 	}
 	// Do not overwrite the file.
-	return filehelper.WriteFile(p, filename, false)
+	return writeFile(p, filename, false)
 }
 
 // CertToPEM converts cert *x509.Certificate to PEM.
@@ -58,16 +55,16 @@ func KeyToPEM(privKey interface{}) (keyPEM []byte, err error) {
 	case *ecdsa.PrivateKey:
 		b, err := x509.MarshalECPrivateKey(k)
 		if err != nil {
-			return nil, fmt.Errorf("unable to marshal ECDSA private key: %s", err.Error())
+			return nil, &EncodingError{Format: "EC private key", Err: err}
 		}
 		pemBlock.Type = "EC PRIVATE KEY"
 		pemBlock.Bytes = b
 	default:
-		return nil, fmt.Errorf("unknown private key type, got %v", k)
+		return nil, &KeyTypeError{Param: "privKey", Key: k}
 	}
 	keyPEM = pem.EncodeToMemory(&pemBlock)
 	if keyPEM == nil {
-		return nil, fmt.Errorf("PEM encoding failed")
+		return nil, &EncodingError{Format: "PEM"}
 	}
 	return keyPEM, nil
 }
@@ -79,5 +76,5 @@ func KeyToPEMFile(privKey interface{}, filename string) error {
 	if err != nil {
 		return err
 	}
-	return filehelper.WriteFile(p, filename, false)
+	return writeFile(p, filename, false)
 }
@@ -0,0 +1,86 @@
+package certhelper
+
+// Fingerprint and public key pinning helpers.
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SPKIPin returns the base64 SHA-256 hash of the DER encoded
+// SubjectPublicKeyInfo of pubKey. This is the HPKP "pin-sha256" format used by
+// most mobile pinning libraries.
+func SPKIPin(pubKey interface{}) (string, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(spki)
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// CertSPKIPin returns the SPKI pin of cert's public key. See SPKIPin.
+func CertSPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// FingerprintSHA1 returns the SHA-1 fingerprint of cert as colon-separated
+// uppercase hex, e.g. "AB:CD:...". Same as "openssl x509 -fingerprint -sha1".
+func FingerprintSHA1(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return colonHex(sum[:])
+}
+
+// FingerprintSHA256 returns the SHA-256 fingerprint of cert as colon-separated
+// uppercase hex. Same as "openssl x509 -fingerprint -sha256".
+func FingerprintSHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return colonHex(sum[:])
+}
+
+// VerifyPins returns a tls.Config.VerifyConnection callback that fails the
+// handshake unless a certificate in the peer's verified chains has one of the
+// SPKI pins, so pinning a root CA works. Other certificates sent by the peer
+// are ignored. With InsecureSkipVerify there are no verified chains and only
+// the peer's leaf certificate is checked. Unlike VerifyPeerCertificate,
+// VerifyConnection is also called for resumed sessions.
+func VerifyPins(pins ...string) func(tls.ConnectionState) error {
+	pinSet := make(map[string]bool)
+	for _, p := range pins {
+		pinSet[p] = true
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) > 0 {
+			for _, chain := range cs.VerifiedChains {
+				for _, c := range chain {
+					if pinSet[CertSPKIPin(c)] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no certificate in the verified chains matches the pins")
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("peer did not send a certificate")
+		}
+		if !pinSet[CertSPKIPin(cs.PeerCertificates[0])] {
+			return fmt.Errorf("peer certificate does not match the pins")
+		}
+		return nil
+	}
+}
+
+// colonHex returns b as colon-separated uppercase hex.
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,180 @@
+package certhelper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPins(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	pin, err := SPKIPin(caPrivKey.Public())
+	if err != nil {
+		t.Fatalf("SPKIPin error: %s", err.Error())
+	}
+	if pin != CertSPKIPin(caCert) {
+		t.Errorf("SPKIPin and CertSPKIPin do not match")
+	}
+	if len(pin) != 44 {
+		t.Errorf("bad pin length, got %d", len(pin))
+	}
+
+	fp1 := FingerprintSHA1(caCert)
+	fp256 := FingerprintSHA256(caCert)
+	if len(strings.Split(fp1, ":")) != 20 || len(strings.Split(fp256, ":")) != 32 {
+		t.Errorf("bad fingerprints, got %s and %s", fp1, fp256)
+	}
+	if strings.ToUpper(fp256) != fp256 {
+		t.Errorf("fingerprint is not uppercase, got %s", fp256)
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	otherCA, _, err := ECRootCA("root2", "org1", "2", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, leafKey, err := CustomECLeafCert("localhost", "org1", "3", "US", "P256",
+		1, caCert, caPrivKey, WithIPAddresses("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  leafKey,
+	}}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	tests := []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{"root pin", []string{CertSPKIPin(otherCA), CertSPKIPin(caCert)}, true},
+		{"leaf pin", []string{CertSPKIPin(leaf)}, true},
+		{"wrong pin", []string{CertSPKIPin(otherCA)}, false},
+	}
+	for _, tt := range tests {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:          roots,
+			VerifyConnection: VerifyPins(tt.pins...),
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
+
+func TestVerifyPinsUnverifiedCerts(t *testing.T) {
+	pinnedCA, _, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	otherCA, otherKey, err := ECRootCA("root2", "org1", "2", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, _, err := ECLeafCert("localhost", "org1", "3", "US", "P256", otherCA, otherKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+
+	// The peer sends a valid chain to an unpinned root and appends the
+	// pinned certificate.
+	verify := VerifyPins(CertSPKIPin(pinnedCA))
+	peer := []*x509.Certificate{leaf, pinnedCA}
+	verified := tls.ConnectionState{PeerCertificates: peer,
+		VerifiedChains: [][]*x509.Certificate{{leaf, otherCA}}}
+	if err := verify(verified); err == nil {
+		t.Errorf("accepted a pin that is not in the verified chain")
+	}
+	// Without verified chains only the leaf is checked.
+	if err := verify(tls.ConnectionState{PeerCertificates: peer}); err == nil {
+		t.Errorf("accepted a pin that is not the leaf")
+	}
+	if err := VerifyPins(CertSPKIPin(leaf))(tls.ConnectionState{PeerCertificates: peer}); err != nil {
+		t.Errorf("rejected the leaf pin, got %v", err)
+	}
+	if err := verify(tls.ConnectionState{}); err == nil {
+		t.Errorf("accepted an empty chain")
+	}
+}
+
+func TestVerifyPinsResumedSession(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	otherCA, _, err := ECRootCA("root2", "org1", "2", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	leaf, leafKey, err := CustomECLeafCert("localhost", "org1", "3", "US", "P256",
+		1, caCert, caPrivKey, WithIPAddresses("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  leafKey,
+	}}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	cache := tls.NewLRUClientSessionCache(1)
+	get := func(pins ...string) (resumed bool, err error) {
+		verify := VerifyPins(pins...)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:            roots,
+			ClientSessionCache: cache,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				resumed = cs.DidResume
+				return verify(cs)
+			},
+		}}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		return resumed, err
+	}
+
+	if _, err := get(CertSPKIPin(caCert)); err != nil {
+		t.Fatalf("first connection failed: %s", err.Error())
+	}
+	// The session is resumed and the pin is still checked.
+	resumed, err := get(CertSPKIPin(otherCA))
+	if !resumed {
+		t.Fatalf("session was not resumed")
+	}
+	if err == nil {
+		t.Errorf("resumed session skipped the pin check")
+	}
+}
@@ -0,0 +1,165 @@
+package certhelper
+
+// Background key generation.
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"sync"
+)
+
+// KeyPool generates keys in background goroutines so callers do not wait for
+// rsa.GenerateKey. Stop it with Close or by canceling its context.
+type KeyPool struct {
+	keys   chan crypto.Signer
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewRSAKeyPool returns a KeyPool that keeps up to size RSA keys of keySize
+// bits ready, generated by workers goroutines.
+func NewRSAKeyPool(ctx context.Context, keySize, size, workers int) *KeyPool {
+	return newKeyPool(ctx, size, workers, func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, keySize)
+	})
+}
+
+// NewECKeyPool returns a KeyPool that keeps up to size EC keys on curve ready,
+// generated by workers goroutines. See ParseCurve for valid curves.
+func NewECKeyPool(ctx context.Context, curve string, size, workers int) *KeyPool {
+	return newKeyPool(ctx, size, workers, func() (crypto.Signer, error) {
+		return ecKey(curve)
+	})
+}
+
+// newKeyPool starts workers goroutines that call gen.
+func newKeyPool(ctx context.Context, size, workers int,
+	gen func() (crypto.Signer, error)) *KeyPool {
+
+	if size < 1 {
+		size = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	p := &KeyPool{keys: make(chan crypto.Signer, size)}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.worker(gen)
+	}
+	return p
+}
+
+// worker generates keys until the pool is stopped.
+func (p *KeyPool) worker(gen func() (crypto.Signer, error)) {
+	defer p.wg.Done()
+	for {
+		k, err := gen()
+		if err != nil {
+			p.mu.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mu.Unlock()
+			p.cancel()
+			return
+		}
+		select {
+		case p.keys <- k:
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+// Get returns a key from the pool and blocks until one is ready. It returns
+// an error after the pool is stopped or if key generation failed.
+func (p *KeyPool) Get() (crypto.Signer, error) {
+	// Do not hand out buffered keys after the pool is stopped.
+	if p.ctx.Err() != nil {
+		return nil, p.Err()
+	}
+	select {
+	case k := <-p.keys:
+		return k, nil
+	case <-p.ctx.Done():
+		return nil, p.Err()
+	}
+}
+
+// Err returns the key generation error or the context error if the pool is
+// stopped, nil otherwise.
+func (p *KeyPool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return p.ctx.Err()
+}
+
+// Close stops the pool and waits for the workers to return.
+func (p *KeyPool) Close() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// KeySource supplies private keys. KeyPool implements it.
+type KeySource interface {
+	Get() (crypto.Signer, error)
+}
+
+// RSAKeySource and ECKeySource supply the keys of the functions that generate
+// RSA and EC keys for certificates if set, e.g., a KeyPool. This includes the
+// root and leaf functions, re-keying, cloning and GeneratePKI. Keys that do not have the requested size
+// or curve are discarded and a new key is generated instead.
+var RSAKeySource, ECKeySource KeySource
+
+// rsaKey returns an RSA key of keySize bits from RSAKeySource or generates
+// one.
+func rsaKey(keySize int) (*rsa.PrivateKey, error) {
+	if RSAKeySource != nil {
+		k, err := RSAKeySource.Get()
+		if err != nil {
+			return nil, err
+		}
+		if rk, ok := k.(*rsa.PrivateKey); ok && rk.N.BitLen() == keySize {
+			return rk, nil
+		}
+	}
+	return rsa.GenerateKey(rand.Reader, keySize)
+}
+
+// sourceECKey returns an EC key on curve from ECKeySource or generates one
+// with ecKey. KeyPool workers must not call it.
+func sourceECKey(name string) (*ecdsa.PrivateKey, error) {
+	if ECKeySource == nil {
+		return ecKey(name)
+	}
+	curve, err := ParseCurve(name)
+	if err != nil {
+		return nil, err
+	}
+	// The policy can change after the key was generated.
+	if CurvePolicy != nil {
+		if err := CurvePolicy(curve); err != nil {
+			return nil, err
+		}
+	}
+	k, err := ECKeySource.Get()
+	if err != nil {
+		return nil, err
+	}
+	if ek, ok := k.(*ecdsa.PrivateKey); ok && ek.Curve == curve.Elliptic() {
+		return ek, nil
+	}
+	return GenerateECKey(curve)
+}
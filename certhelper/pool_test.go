@@ -0,0 +1,109 @@
+package certhelper
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestKeyPool(t *testing.T) {
+	rsaPool := NewRSAKeyPool(context.Background(), 2048, 2, 2)
+	defer rsaPool.Close()
+	ecPool := NewECKeyPool(context.Background(), "P256", 4, 1)
+	defer ecPool.Close()
+
+	caKey, err := ecPool.Get()
+	if err != nil {
+		t.Fatalf("error getting EC key: %s", err.Error())
+	}
+	caCert, err := CustomRootCAWithKey("root1", "org1", "1", "US", 1, 0,
+		CAKeyUsageConstant, caKey)
+	if err != nil {
+		t.Fatalf("CustomRootCAWithKey error: %s", err.Error())
+	}
+	seen := make(map[interface{}]bool)
+	for i := 0; i < 3; i++ {
+		key, err := rsaPool.Get()
+		if err != nil {
+			t.Fatalf("error getting RSA key: %s", err.Error())
+		}
+		if seen[key] {
+			t.Errorf("pool returned the same key twice")
+		}
+		seen[key] = true
+		cert, err := CustomLeafCertWithKey("leaf1", "org1", "2", "US", 1, key, caCert, caKey)
+		if err != nil {
+			t.Fatalf("CustomLeafCertWithKey error: %s", err.Error())
+		}
+		if ok, _ := KeyMatchesCert(cert, key); !ok {
+			t.Errorf("leaf does not match the pooled key")
+		}
+	}
+
+	// Get should fail after the pool is closed.
+	rsaPool.Close()
+	if _, err := rsaPool.Get(); err == nil {
+		t.Errorf("Get succeeded on a closed pool")
+	}
+}
+
+func TestKeyPoolError(t *testing.T) {
+	// Invalid key sizes stop the pool.
+	p := NewRSAKeyPool(context.Background(), 1, 1, 1)
+	defer p.Close()
+	if _, err := p.Get(); err == nil {
+		t.Errorf("Get succeeded with an invalid key size")
+	}
+}
+
+// fixedKeySource returns the same key from Get.
+type fixedKeySource struct{ key crypto.Signer }
+
+func (s fixedKeySource) Get() (crypto.Signer, error) { return s.key, nil }
+
+func TestKeySources(t *testing.T) {
+	defer func() { RSAKeySource, ECKeySource = nil, nil }()
+
+	ecPool := NewECKeyPool(context.Background(), "P256", 2, 1)
+	defer ecPool.Close()
+	ECKeySource = ecPool
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %s", err.Error())
+	}
+	RSAKeySource = fixedKeySource{key}
+	_, leafKey, err := RSALeafCert("leaf1", "org1", "2", "US", 2048, caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	if leafKey != key {
+		t.Errorf("RSALeafCert did not use the RSA key source")
+	}
+
+	// Keys on other curves are not used.
+	ECKeySource = fixedKeySource{caPrivKey}
+	_, ecLeafKey, err := ECLeafCert("leaf2", "org1", "3", "US", "P256", caCert, caPrivKey)
+	if err != nil || ecLeafKey != caPrivKey {
+		t.Errorf("ECLeafCert did not use the EC key source, got %v", err)
+	}
+	_, ecLeafKey, err = ECLeafCert("leaf3", "org1", "4", "US", "P384", caCert, caPrivKey)
+	if err != nil || ecLeafKey == caPrivKey || ecLeafKey.Curve != CurveP384.Elliptic() {
+		t.Errorf("ECLeafCert used a key on the wrong curve, got %v", err)
+	}
+
+	// Re-keying and spec certificates use the sources too.
+	if _, rekeyed, err := RekeyRSARootCA(caCert, 2048, "5", 1); err != nil || rekeyed != key {
+		t.Errorf("RekeyRSARootCA did not use the RSA key source, got %v", err)
+	}
+	cs := CertSpec{Name: "root", CommonName: "root"}
+	if _, specKey, err := issueSpecCert(cs, true, nil); err != nil || specKey != caPrivKey {
+		t.Errorf("issueSpecCert did not use the EC key source, got %v", err)
+	}
+}
@@ -0,0 +1,400 @@
+package certhelper
+
+// Certificate rotation.
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IssueFunc returns a new certificate, the CA certificates that are sent after
+// it in the TLS handshake and its private key. Rotator calls it when the
+// current certificate needs to be renewed.
+type IssueFunc func() (cert *x509.Certificate, chain []*x509.Certificate,
+	key interface{}, err error)
+
+// ECLeafIssuer returns an IssueFunc that creates EC leaf certificates signed by
+// caCert with caPrivKey. Serial numbers are random. chain is returned with
+// every certificate, e.g., caCert if it is an intermediate.
+func ECLeafIssuer(commonName, orgUnit, countryCode, curve string, validity int,
+	caCert *x509.Certificate, caPrivKey interface{}, chain ...*x509.Certificate) IssueFunc {
+
+	return func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cert, key, err := CustomECLeafCert(commonName, orgUnit, serial,
+			countryCode, curve, validity, caCert, caPrivKey)
+		return cert, chain, key, err
+	}
+}
+
+// RSALeafIssuer returns an IssueFunc that creates RSA leaf certificates signed
+// by caCert with caPrivKey. Serial numbers are random. chain is returned with
+// every certificate, e.g., caCert if it is an intermediate.
+func RSALeafIssuer(commonName, orgUnit, countryCode string, validity,
+	keySize int, caCert *x509.Certificate, caPrivKey interface{},
+	chain ...*x509.Certificate) IssueFunc {
+
+	return func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cert, key, err := CustomRSALeafCert(commonName, orgUnit, serial,
+			countryCode, validity, keySize, caCert, caPrivKey)
+		return cert, chain, key, err
+	}
+}
+
+// RotationEvent is passed to Rotator.OnRotate after each rotation attempt and
+// after each reload of files modified by another process. Err is set if the
+// attempt failed, in that case New is nil and Old is still being served.
+type RotationEvent struct {
+	Old  *x509.Certificate
+	New  *x509.Certificate
+	Time time.Time
+	Err  error
+}
+
+// Rotator serves a certificate and reissues it before it expires. Use
+// GetCertificate in a tls.Config to always serve the current certificate. The
+// chain from IssueFunc is sent after the certificate.
+type Rotator struct {
+	// RenewBefore is the fraction of the certificate's lifetime before
+	// NotAfter when it is reissued. For example, 0.3 renews the certificate
+	// when 30% of its lifetime is left. Default is RenewBeforeConstant.
+	RenewBefore float64
+	// CheckInterval is how often Run checks the certificate. Default is
+	// RotationCheckIntervalConstant.
+	CheckInterval time.Duration
+	// OnRotate is called after each rotation attempt and reload, see
+	// RotationEvent. Can be nil.
+	OnRotate func(RotationEvent)
+
+	issue             IssueFunc
+	certFile, keyFile string
+
+	// rotateMu serializes rotations and reloads.
+	rotateMu sync.Mutex
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// NewRotator returns a Rotator that keeps certificates in memory. The first
+// certificate is issued immediately.
+func NewRotator(issue IssueFunc) (*Rotator, error) {
+	r := &Rotator{
+		RenewBefore:   RenewBeforeConstant,
+		CheckInterval: RotationCheckIntervalConstant,
+		issue:         issue,
+	}
+	if err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewFileRotator returns a Rotator that stores certificates and keys in PEM
+// files. If both files exist they are loaded, otherwise a new certificate is
+// issued. Files modified by another process are reloaded by Check.
+func NewFileRotator(certFile, keyFile string, issue IssueFunc) (*Rotator, error) {
+	r := &Rotator{
+		RenewBefore:   RenewBeforeConstant,
+		CheckInterval: RotationCheckIntervalConstant,
+		issue:         issue,
+		certFile:      certFile,
+		keyFile:       keyFile,
+	}
+	if err := r.load(); err == nil {
+		return r, nil
+	}
+	if err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It can be used as
+// tls.Config.GetCertificate.
+func (r *Rotator) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Certificate returns the current leaf certificate.
+func (r *Rotator) Certificate() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf
+}
+
+// NeedsRotation returns true if the current certificate should be renewed at
+// time now.
+func (r *Rotator) NeedsRotation(now time.Time) bool {
+	return needsRenewal(r.Certificate(), r.RenewBefore, now)
+}
+
+// Rotate issues a new certificate and swaps it in. Concurrent calls are
+// serialized.
+func (r *Rotator) Rotate() error {
+	r.rotateMu.Lock()
+	defer r.rotateMu.Unlock()
+	return r.rotate()
+}
+
+// Check reloads modified files and rotates the certificate if needed. Files
+// that can not be loaded, e.g., missing or corrupted files, are replaced with
+// a new certificate. Errors are also reported via OnRotate.
+func (r *Rotator) Check() error {
+	r.rotateMu.Lock()
+	defer r.rotateMu.Unlock()
+	if r.certFile != "" {
+		// reload reports its error, the current files can not be used.
+		if err := r.reload(); err != nil {
+			return r.rotate()
+		}
+	}
+	if r.NeedsRotation(time.Now()) {
+		return r.rotate()
+	}
+	return nil
+}
+
+// Run calls Check every CheckInterval until ctx is done. Errors are reported
+// via OnRotate.
+func (r *Rotator) Run(ctx context.Context) {
+	t := time.NewTicker(r.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// Check reports its errors via OnRotate.
+			r.Check()
+		}
+	}
+}
+
+// rotate issues a new certificate and swaps it in. The caller must hold
+// rotateMu.
+func (r *Rotator) rotate() error {
+	var old *x509.Certificate
+	r.mu.RLock()
+	if r.cert != nil {
+		old = r.cert.Leaf
+	}
+	r.mu.RUnlock()
+
+	cert, chain, key, err := r.issue()
+	if err == nil && r.certFile != "" {
+		err = r.store(cert, chain, key)
+	}
+	if err == nil {
+		r.set(cert, chain, key)
+	} else {
+		cert = nil
+	}
+	r.report(old, cert, err)
+	return err
+}
+
+// reload loads the files if the certificate file was modified since it was
+// last loaded or stored. The caller must hold rotateMu.
+func (r *Rotator) reload() error {
+	r.mu.RLock()
+	modTime := r.modTime
+	old := r.cert.Leaf
+	r.mu.RUnlock()
+
+	fi, err := os.Stat(r.certFile)
+	if err == nil && !fi.ModTime().After(modTime) {
+		return nil
+	}
+	var cert *x509.Certificate
+	if err == nil {
+		err = r.load()
+	}
+	if err == nil {
+		cert = r.Certificate()
+	}
+	r.report(old, cert, err)
+	return err
+}
+
+// report calls OnRotate if it is set.
+func (r *Rotator) report(old, cert *x509.Certificate, err error) {
+	if r.OnRotate != nil {
+		r.OnRotate(RotationEvent{Old: old, New: cert, Time: time.Now(), Err: err})
+	}
+}
+
+// set swaps in cert, chain and key.
+func (r *Rotator) set(cert *x509.Certificate, chain []*x509.Certificate, key interface{}) {
+	tlsCert := tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}
+	for _, c := range chain {
+		tlsCert.Certificate = append(tlsCert.Certificate, c.Raw)
+	}
+	r.mu.Lock()
+	r.cert = &tlsCert
+	r.mu.Unlock()
+}
+
+// load reads the certificate, chain and key from disk.
+func (r *Rotator) load() error {
+	fi, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &pair
+	r.modTime = fi.ModTime()
+	r.mu.Unlock()
+	return nil
+}
+
+// store replaces the certificate and key files. The certificate file also
+// contains the chain. See replaceKeyPair.
+func (r *Rotator) store(cert *x509.Certificate, chain []*x509.Certificate, key interface{}) error {
+	certPEM, err := chainToPEM(cert, chain)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := KeyToPEM(key)
+	if err != nil {
+		return err
+	}
+	if err := replaceKeyPair(r.certFile, certPEM, r.keyFile, keyPEM); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(r.certFile); err == nil {
+		r.setModTime(fi.ModTime())
+	}
+	return nil
+}
+
+// setModTime records the modification time of the certificate file.
+func (r *Rotator) setModTime(t time.Time) {
+	r.mu.Lock()
+	r.modTime = t
+	r.mu.Unlock()
+}
+
+// replaceKeyPair replaces certFile and keyFile. Both are written to temporary
+// files first, then the key is renamed and the certificate last. A reader
+// that opens the files between the two renames gets the new key with the old
+// certificate. tls.LoadX509KeyPair rejects that pair and Rotator.Check loads
+// the files again once the modification time of certFile changes.
+func replaceKeyPair(certFile string, certPEM []byte, keyFile string, keyPEM []byte) error {
+	keyTmp, err := writeTemp(keyFile, keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := writeTemp(certFile, certPEM, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		return fmt.Errorf("replace %s: %w", keyFile, err)
+	}
+	if err := os.Rename(certTmp, certFile); err != nil {
+		return fmt.Errorf("replace %s: %w", certFile, err)
+	}
+	return nil
+}
+
+// replaceFile writes data with perm to a temporary file and renames it to
+// filename.
+func replaceFile(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTemp(filename, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("replace %s: %w", filename, err)
+	}
+	return nil
+}
+
+// writeTemp writes data with perm to a temporary file next to filename and
+// returns its name.
+func writeTemp(filename string, data []byte, perm os.FileMode) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// chainToPEM returns cert followed by chain as PEM.
+func chainToPEM(cert *x509.Certificate, chain []*x509.Certificate) ([]byte, error) {
+	var out []byte
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		p, err := CertToPEM(c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p...)
+	}
+	return out, nil
+}
+
+// needsRenewal returns true if less than renewBefore of leaf's lifetime is
+// left at now.
+func needsRenewal(leaf *x509.Certificate, renewBefore float64, now time.Time) bool {
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	renewAt := leaf.NotAfter.Add(-time.Duration(float64(lifetime) * renewBefore))
+	return !now.Before(renewAt)
+}
+
+// randomSerial returns a random positive 127-bit serial number as a decimal
+// string.
+func randomSerial() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[0] &= 0x7f
+	return new(big.Int).SetBytes(b).String(), nil
+}
@@ -0,0 +1,245 @@
+package certhelper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// shortLivedIssuer returns an IssueFunc for EC leaf certificates that are
+// valid for lifetime. The CA certificate is returned as the chain.
+func shortLivedIssuer(t *testing.T, lifetime time.Duration) IssueFunc {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1234", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	chain := []*x509.Certificate{caCert}
+	return func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		key, err := GenerateECKey(CurveP256)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tmpl, err := LeafTemplate("leaf", "org1", serial, "US", "EC")
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tmpl.NotAfter = tmpl.NotBefore.Add(lifetime)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caPrivKey)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		return cert, chain, key, err
+	}
+}
+
+func TestRotator(t *testing.T) {
+	var events []RotationEvent
+	r, err := NewRotator(shortLivedIssuer(t, time.Hour))
+	if err != nil {
+		t.Fatalf("error in NewRotator: %s", err.Error())
+	}
+	r.OnRotate = func(e RotationEvent) { events = append(events, e) }
+
+	first := r.Certificate()
+	// The chain is served with the first certificate.
+	if got, _ := r.GetCertificate(&tls.ClientHelloInfo{}); len(got.Certificate) != 2 {
+		t.Errorf("first certificate was served without its chain, got %d certificates",
+			len(got.Certificate))
+	}
+	if r.NeedsRotation(first.NotBefore.Add(30 * time.Minute)) {
+		t.Errorf("NeedsRotation is true halfway through the lifetime")
+	}
+	if !r.NeedsRotation(first.NotBefore.Add(45 * time.Minute)) {
+		t.Errorf("NeedsRotation is false with 25%% of the lifetime left")
+	}
+
+	if err := r.Rotate(); err != nil {
+		t.Fatalf("error in Rotate: %s", err.Error())
+	}
+	got, _ := r.GetCertificate(&tls.ClientHelloInfo{})
+	if got.Leaf.Equal(first) {
+		t.Errorf("GetCertificate returned the old certificate")
+	}
+	if len(events) != 1 || events[0].Old != first || events[0].New != got.Leaf {
+		t.Errorf("OnRotate error: got %+v", events)
+	}
+}
+
+func TestFileRotator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	issue := shortLivedIssuer(t, time.Hour)
+
+	r1, err := NewFileRotator(certFile, keyFile, issue)
+	if err != nil {
+		t.Fatalf("error in NewFileRotator: %s", err.Error())
+	}
+	// The second rotator should load the files instead of issuing.
+	r2, err := NewFileRotator(certFile, keyFile, issue)
+	if err != nil {
+		t.Fatalf("error in NewFileRotator: %s", err.Error())
+	}
+	if !r1.Certificate().Equal(r2.Certificate()) {
+		t.Errorf("NewFileRotator did not load the existing certificate")
+	}
+	if got, _ := r2.GetCertificate(&tls.ClientHelloInfo{}); len(got.Certificate) != 2 {
+		t.Errorf("loaded certificate has no chain, got %d certificates", len(got.Certificate))
+	}
+	// Rotating r1 should be picked up by r2.
+	if err := r1.Rotate(); err != nil {
+		t.Fatalf("error in Rotate: %s", err.Error())
+	}
+	// Make sure the modification time is newer on filesystems with coarse
+	// timestamps.
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if err := r2.Check(); err != nil {
+		t.Fatalf("error in Check: %s", err.Error())
+	}
+	if !r1.Certificate().Equal(r2.Certificate()) {
+		t.Errorf("Check did not reload the rotated certificate")
+	}
+}
+
+func TestRotatorRunReportsErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+
+	r, err := NewFileRotator(certFile, filepath.Join(dir, "key.pem"),
+		shortLivedIssuer(t, time.Hour))
+	if err != nil {
+		t.Fatalf("error in NewFileRotator: %s", err.Error())
+	}
+	events := make(chan RotationEvent, 1)
+	r.OnRotate = func(e RotationEvent) {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+	r.CheckInterval = 10 * time.Millisecond
+
+	// Another process writes a broken certificate file.
+	if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+	select {
+	case e := <-events:
+		if e.Err == nil || e.New != nil || e.Old == nil {
+			t.Errorf("bad event for a broken file, got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run did not report the reload error")
+	}
+}
+
+func TestRotatorCheckReplacesBrokenFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	r, err := NewFileRotator(certFile, keyFile, shortLivedIssuer(t, time.Hour))
+	if err != nil {
+		t.Fatalf("error in NewFileRotator: %s", err.Error())
+	}
+	breakFile := []func() error{
+		func() error {
+			if err := ioutil.WriteFile(certFile, []byte("not a certificate"), 0644); err != nil {
+				return err
+			}
+			future := time.Now().Add(time.Minute)
+			return os.Chtimes(certFile, future, future)
+		},
+		func() error { return os.Remove(certFile) },
+	}
+	for i, broken := range breakFile {
+		old := r.Certificate()
+		if err := broken(); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Check(); err != nil {
+			t.Errorf("%d: Check error: %s", i, err.Error())
+		}
+		if r.Certificate().Equal(old) {
+			t.Errorf("%d: Check did not rotate the certificate", i)
+		}
+		if _, err := LoadIssuedCert(certFile, keyFile); err != nil {
+			t.Errorf("%d: files were not replaced: %s", i, err.Error())
+		}
+	}
+}
+
+func TestRotatorSerializesRotations(t *testing.T) {
+	issue := shortLivedIssuer(t, time.Hour)
+	var running, overlaps int32
+	r, err := NewRotator(func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.AddInt32(&running, -1)
+		time.Sleep(time.Millisecond)
+		return issue()
+	})
+	if err != nil {
+		t.Fatalf("error in NewRotator: %s", err.Error())
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Rotate()
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 {
+		t.Errorf("concurrent Rotate calls were not serialized")
+	}
+
+	// Failed rotations keep serving the old certificate.
+	var event RotationEvent
+	r.OnRotate = func(e RotationEvent) { event = e }
+	old := r.Certificate()
+	r.issue = func() (*x509.Certificate, []*x509.Certificate, interface{}, error) {
+		return nil, nil, nil, errors.New("CA is down")
+	}
+	if err := r.Rotate(); err == nil {
+		t.Errorf("Rotate did not return the issuer error")
+	}
+	if event.Err == nil || event.Old != old || r.Certificate() != old {
+		t.Errorf("bad failed rotation, got %+v", event)
+	}
+}
@@ -0,0 +1,361 @@
+package certhelper
+
+// Batch issuance from a declarative PKI spec.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/parsiya/go-utils/filehelper"
+	"gopkg.in/yaml.v3"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// PKISpec describes a certificate hierarchy. CAs are created in order so an
+// issuer must appear before the certificates it signs.
+type PKISpec struct {
+	CAs   []CertSpec `json:"cas" yaml:"cas"`
+	Certs []CertSpec `json:"certs" yaml:"certs"`
+}
+
+// CertSpec describes one certificate in a PKISpec.
+type CertSpec struct {
+	// Name is the unique name of the certificate and the base name of its
+	// output files.
+	Name string `json:"name" yaml:"name"`
+	// Issuer is the Name of the issuing CA. Empty creates a self-signed root.
+	// Required for leaf certificates.
+	Issuer       string `json:"issuer" yaml:"issuer"`
+	CommonName   string `json:"commonName" yaml:"commonName"`
+	OrgUnit      string `json:"orgUnit" yaml:"orgUnit"`
+	CountryCode  string `json:"countryCode" yaml:"countryCode"`
+	SerialNumber string `json:"serialNumber" yaml:"serialNumber"`
+	// KeyType is "RSA" or "EC" (case-insensitive). Default is "EC".
+	KeyType string `json:"keyType" yaml:"keyType"`
+	// KeySize is the RSA key size. Default is 2048.
+	KeySize int `json:"keySize" yaml:"keySize"`
+	// Curve is the EC curve. Default is "P256".
+	Curve string `json:"curve" yaml:"curve"`
+	// Validity is in years. Default is CertValidityConstant.
+	Validity int `json:"validity" yaml:"validity"`
+	// MaxPathLen is only used for CAs.
+	MaxPathLen int `json:"maxPathLen" yaml:"maxPathLen"`
+	// Profile is "server", "client", "codeSigning" or empty for leaf
+	// certificates.
+	Profile        string   `json:"profile" yaml:"profile"`
+	DNSNames       []string `json:"dnsNames" yaml:"dnsNames"`
+	IPAddresses    []string `json:"ipAddresses" yaml:"ipAddresses"`
+	EmailAddresses []string `json:"emailAddresses" yaml:"emailAddresses"`
+	// PKCS12Password creates a "Name.p12" file with the key and chain if set.
+	PKCS12Password string `json:"pkcs12Password" yaml:"pkcs12Password"`
+}
+
+// LoadPKISpec reads a spec from a JSON or YAML file. The format is picked by
+// the extension: ".json", ".yaml" or ".yml".
+func LoadPKISpec(filename string) (*PKISpec, error) {
+	data, err := filehelper.ReadFileByte(filename)
+	if err != nil {
+		return nil, err
+	}
+	var spec PKISpec
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		err = json.Unmarshal(data, &spec)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &spec)
+	default:
+		return nil, fmt.Errorf("spec must be a .json, .yaml or .yml file, got %s", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// GeneratePKI creates the certificates in spec and stores them in dir as
+// "Name.crt" and "Name.key" (and "Name.p12" if requested). dir is created if
+// it does not exist. Keys and PKCS#12 files are only readable by the owner.
+// It is idempotent: existing files are reused if they still match the spec
+// and their issuer and have not expired, otherwise they are replaced. Returns the certificates and keys by name.
+func GeneratePKI(spec *PKISpec, dir string) (map[string]CertKeyPair, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	pairs := make(map[string]CertKeyPair)
+	for _, cs := range spec.CAs {
+		if err := generateSpecCert(cs, true, dir, pairs); err != nil {
+			return nil, fmt.Errorf("%s: %w", cs.Name, err)
+		}
+	}
+	for _, cs := range spec.Certs {
+		if cs.Issuer == "" {
+			return nil, fmt.Errorf("%s: leaf certificates need an issuer", cs.Name)
+		}
+		if err := generateSpecCert(cs, false, dir, pairs); err != nil {
+			return nil, fmt.Errorf("%s: %w", cs.Name, err)
+		}
+	}
+	return pairs, nil
+}
+
+// generateSpecCert loads or creates one certificate and adds it to pairs.
+func generateSpecCert(cs CertSpec, isCA bool, dir string, pairs map[string]CertKeyPair) error {
+	if cs.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, ok := pairs[cs.Name]; ok {
+		return fmt.Errorf("duplicate name")
+	}
+	var issuer *CertKeyPair
+	if cs.Issuer != "" {
+		p, ok := pairs[cs.Issuer]
+		if !ok || !p.Cert.IsCA {
+			return fmt.Errorf("issuer %s is not a CA defined before this certificate", cs.Issuer)
+		}
+		issuer = &p
+	}
+	certFile := filepath.Join(dir, cs.Name+".crt")
+	keyFile := filepath.Join(dir, cs.Name+".key")
+
+	// Reuse the existing files if they are still valid.
+	if certs, keys, err := ParsePEMFiles(certFile, keyFile); err == nil &&
+		len(certs) == 1 && len(keys) == 1 && specMatches(cs, isCA, certs[0], keys[0], issuer) {
+		pairs[cs.Name] = CertKeyPair{Cert: certs[0], Key: keys[0]}
+		return writeSpecPKCS12(cs, dir, pairs)
+	}
+
+	cert, key, err := issueSpecCert(cs, isCA, issuer)
+	if err != nil {
+		return err
+	}
+	certPEM, err := CertToPEM(cert)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := KeyToPEM(key)
+	if err != nil {
+		return err
+	}
+	if err := replaceKeyPair(certFile, certPEM, keyFile, keyPEM); err != nil {
+		return err
+	}
+	pairs[cs.Name] = CertKeyPair{Cert: cert, Key: key}
+	return writeSpecPKCS12(cs, dir, pairs)
+}
+
+// issueSpecCert creates a key and certificate for cs.
+func issueSpecCert(cs CertSpec, isCA bool, issuer *CertKeyPair) (*x509.Certificate,
+	crypto.Signer, error) {
+
+	serial := cs.SerialNumber
+	if serial == "" {
+		var err error
+		if serial, err = randomSerial(); err != nil {
+			return nil, nil, err
+		}
+	}
+	opts, err := specOptions(cs)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key crypto.Signer
+	if cs.keyType() == "EC" {
+		key, err = sourceECKey(cs.curve())
+	} else {
+		key, err = rsaKey(cs.keySize())
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cert *x509.Certificate
+	if issuer == nil {
+		cert, err = CustomRootCAWithKey(cs.CommonName, cs.OrgUnit, serial,
+			cs.CountryCode, cs.validity(), cs.MaxPathLen, CAKeyUsageConstant, key, opts...)
+		return cert, key, err
+	}
+	caKey, ok := issuer.Key.(crypto.Signer)
+	if !ok {
+		return nil, nil, &KeyTypeError{Param: "issuer key", Key: issuer.Key}
+	}
+	if isCA {
+		cert, err = CustomIntermediateCAWithKey(cs.CommonName, cs.OrgUnit, serial,
+			cs.CountryCode, cs.validity(), cs.MaxPathLen, CAKeyUsageConstant, key,
+			issuer.Cert, caKey, opts...)
+	} else {
+		cert, err = CustomLeafCertWithKey(cs.CommonName, cs.OrgUnit, serial,
+			cs.CountryCode, cs.validity(), key, issuer.Cert, caKey, opts...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// specOptions returns the template options for the SANs and profile of cs.
+func specOptions(cs CertSpec) ([]TemplateOption, error) {
+	if algo := cs.keyType(); algo != "EC" && algo != "RSA" {
+		return nil, fmt.Errorf("%w: keyType must be EC or RSA, got %s", ErrUnsupportedAlgorithm, cs.KeyType)
+	}
+	opts := []TemplateOption{
+		WithDNSNames(cs.DNSNames...),
+		WithIPAddresses(cs.IPAddresses...),
+		WithEmailAddresses(cs.EmailAddresses...),
+	}
+	switch strings.ToLower(cs.Profile) {
+	case "":
+	case "server":
+		opts = append(opts, WithExtKeyUsage(x509.ExtKeyUsageServerAuth))
+	case "client":
+		opts = append(opts, WithExtKeyUsage(x509.ExtKeyUsageClientAuth))
+	case "codesigning":
+		opts = append(opts, WithCodeSigning())
+	default:
+		return nil, fmt.Errorf("profile must be server, client, codeSigning or empty, got %s",
+			cs.Profile)
+	}
+	return opts, nil
+}
+
+// specTemplate returns a template with the properties issueSpecCert gives
+// certificates for cs and serial, to compare with existing certificates.
+func specTemplate(cs CertSpec, isCA bool, serial string) (*x509.Certificate, error) {
+	opts, err := specOptions(cs)
+	if err != nil {
+		return nil, err
+	}
+	if isCA {
+		return CustomCATemplate(cs.CommonName, cs.OrgUnit, serial, cs.CountryCode,
+			cs.keyType(), cs.validity(), cs.MaxPathLen, CAKeyUsageConstant, opts...)
+	}
+	return CustomLeafTemplate(cs.CommonName, cs.OrgUnit, serial, cs.CountryCode,
+		cs.keyType(), cs.validity(), LeafKeyUsageConstant, opts...)
+}
+
+// specMatches returns true if an existing certificate and key can be reused
+// for cs: the key matches, the certificate has not expired, is issued by
+// issuer and has the subject, serial number, key, validity, SANs, key usages
+// and basic constraints of cs.
+func specMatches(cs CertSpec, isCA bool, cert *x509.Certificate, key interface{},
+	issuer *CertKeyPair) bool {
+
+	if ok, _ := KeyMatchesCert(cert, key); !ok {
+		return false
+	}
+	if !time.Now().Before(cert.NotAfter) {
+		return false
+	}
+	parent := cert
+	if issuer != nil {
+		parent = issuer.Cert
+	}
+	if !IssuedBy(cert, parent) {
+		return false
+	}
+	// Certificates without a serial number in the spec keep their serial.
+	serial := cs.SerialNumber
+	if serial == "" {
+		serial = cert.Subject.SerialNumber
+	}
+	tmpl, err := specTemplate(cs, isCA, serial)
+	if err != nil {
+		return false
+	}
+	diffs, err := Diff(tmpl, cert)
+	if err != nil {
+		return false
+	}
+	if len(diffs.Filter("SerialNumber", "Subject", "KeyUsage", "ExtKeyUsage",
+		"BasicConstraints", "DNSNames", "IPAddresses", "EmailAddresses")) > 0 {
+		return false
+	}
+	// Allow a minute between NotBefore and NotAfter in the template.
+	want := cert.NotBefore.AddDate(cs.validity(), 0, 0)
+	if d := cert.NotAfter.Sub(want); d < -time.Minute || d > time.Minute {
+		return false
+	}
+	switch pub := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		curve, err := ParseCurve(cs.curve())
+		return err == nil && cs.keyType() == "EC" && pub.Curve == curve.Elliptic()
+	case *rsa.PublicKey:
+		return cs.keyType() == "RSA" && pub.N.BitLen() == cs.keySize()
+	default:
+		return false
+	}
+}
+
+// keyType returns the upper case key type of cs. Default is "EC".
+func (cs CertSpec) keyType() string {
+	if cs.KeyType == "" {
+		return "EC"
+	}
+	return strings.ToUpper(cs.KeyType)
+}
+
+// curve returns the curve of cs. Default is "P256".
+func (cs CertSpec) curve() string {
+	if cs.Curve == "" {
+		return "P256"
+	}
+	return cs.Curve
+}
+
+// keySize returns the RSA key size of cs. Default is 2048.
+func (cs CertSpec) keySize() int {
+	if cs.KeySize == 0 {
+		return 2048
+	}
+	return cs.KeySize
+}
+
+// validity returns the validity of cs in years. Default is
+// CertValidityConstant.
+func (cs CertSpec) validity() int {
+	if cs.Validity == 0 {
+		return CertValidityConstant
+	}
+	return cs.Validity
+}
+
+// writeSpecPKCS12 creates the PKCS#12 file for cs if requested. The chain is
+// built by following issuers in pairs.
+func writeSpecPKCS12(cs CertSpec, dir string, pairs map[string]CertKeyPair) error {
+	if cs.PKCS12Password == "" {
+		return nil
+	}
+	p := pairs[cs.Name]
+	p12File := filepath.Join(dir, cs.Name+".p12")
+	// Keep the existing file if it has the same certificate.
+	if data, err := filehelper.ReadFileByte(p12File); err == nil {
+		if _, cert, _, err := pkcs12.DecodeChain(data, cs.PKCS12Password); err == nil && cert.Equal(p.Cert) {
+			return nil
+		}
+	}
+	var chain []*x509.Certificate
+	var all []*x509.Certificate
+	for _, pair := range pairs {
+		all = append(all, pair.Cert)
+	}
+	for c := p.Cert; ; {
+		next := findIssuer(c, all, append(chain, p.Cert))
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+		c = next
+	}
+	pfx, err := pkcs12.Modern.Encode(p.Key, p.Cert, chain, cs.PKCS12Password)
+	if err != nil {
+		return err
+	}
+	return replaceFile(p12File, pfx, 0600)
+}
@@ -0,0 +1,176 @@
+package certhelper
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPKISpec = `
+cas:
+  - name: root
+    commonName: Test Root
+    orgUnit: org1
+    countryCode: US
+    maxPathLen: 1
+  - name: inter
+    issuer: root
+    commonName: Test Intermediate
+    orgUnit: org1
+    countryCode: US
+    keyType: RSA
+certs:
+  - name: server
+    issuer: inter
+    commonName: server.example.com
+    profile: server
+    dnsNames: [server.example.com, localhost]
+    ipAddresses: [127.0.0.1]
+  - name: alice
+    issuer: inter
+    commonName: alice
+    curve: P384
+    profile: client
+    emailAddresses: [alice@example.com]
+    pkcs12Password: changeit
+`
+
+func TestGeneratePKI(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	specFile := filepath.Join(tmp, "pki.yaml")
+	if err := ioutil.WriteFile(specFile, []byte(testPKISpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadPKISpec(specFile)
+	if err != nil {
+		t.Fatalf("LoadPKISpec error: %s", err.Error())
+	}
+
+	// GeneratePKI creates the output directory.
+	dir := filepath.Join(tmp, "pki")
+	first, err := GeneratePKI(spec, dir)
+	if err != nil {
+		t.Fatalf("GeneratePKI error: %s", err.Error())
+	}
+	server := first["server"].Cert
+	// Key usages match the root and leaf functions.
+	if first["root"].Cert.KeyUsage != CAKeyUsageConstant ||
+		first["inter"].Cert.KeyUsage != CAKeyUsageConstant ||
+		server.KeyUsage != LeafKeyUsageConstant {
+		t.Errorf("bad key usages, got %v, %v and %v", first["root"].Cert.KeyUsage,
+			first["inter"].Cert.KeyUsage, server.KeyUsage)
+	}
+	if len(server.DNSNames) != 2 || len(server.IPAddresses) != 1 ||
+		server.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("server certificate does not match the spec")
+	}
+	_, err = VerifyChain(first["alice"].Cert, []*x509.Certificate{first["inter"].Cert},
+		[]*x509.Certificate{first["root"].Cert})
+	if err != nil {
+		t.Errorf("alice did not verify: %s", err.Error())
+	}
+	for _, name := range []string{"alice.p12", "alice.key", "root.key"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s missing: %s", name, err.Error())
+		} else if info.Mode().Perm() != 0600 {
+			t.Errorf("%s is readable by others, got %s", name, info.Mode())
+		}
+	}
+
+	// A second run should reuse everything.
+	second, err := GeneratePKI(spec, dir)
+	if err != nil {
+		t.Fatalf("GeneratePKI error: %s", err.Error())
+	}
+	for name, p := range first {
+		if !second[name].Cert.Equal(p.Cert) {
+			t.Errorf("%s was regenerated", name)
+		}
+	}
+
+	// Removing the intermediate should regenerate it and its leaves but not
+	// the root.
+	os.Remove(filepath.Join(dir, "inter.key"))
+	third, err := GeneratePKI(spec, dir)
+	if err != nil {
+		t.Fatalf("GeneratePKI error: %s", err.Error())
+	}
+	if !third["root"].Cert.Equal(first["root"].Cert) {
+		t.Errorf("root was regenerated")
+	}
+	for _, name := range []string{"inter", "server", "alice"} {
+		if third[name].Cert.Equal(first[name].Cert) {
+			t.Errorf("%s was not regenerated", name)
+		}
+	}
+}
+
+func TestSpecMatches(t *testing.T) {
+	cs := CertSpec{Name: "root", CommonName: "root", Validity: 1, MaxPathLen: 1,
+		DNSNames: []string{"root.test"}}
+	cert, key, err := issueSpecCert(cs, true, nil)
+	if err != nil {
+		t.Fatalf("issueSpecCert error: %s", err.Error())
+	}
+	if !specMatches(cs, true, cert, key, nil) {
+		t.Fatalf("certificate does not match its spec")
+	}
+
+	changes := map[string]func(cs *CertSpec){
+		"commonName": func(cs *CertSpec) { cs.CommonName = "other" },
+		"serial":     func(cs *CertSpec) { cs.SerialNumber = "42" },
+		"keyType":    func(cs *CertSpec) { cs.KeyType = "RSA" },
+		"curve":      func(cs *CertSpec) { cs.Curve = "P384" },
+		"validity":   func(cs *CertSpec) { cs.Validity = 2 },
+		"maxPathLen": func(cs *CertSpec) { cs.MaxPathLen = 0 },
+		"dnsNames":   func(cs *CertSpec) { cs.DNSNames = append(cs.DNSNames, "new.test") },
+		"profile":    func(cs *CertSpec) { cs.Profile = "server" },
+	}
+	for name, change := range changes {
+		changed := cs
+		changed.DNSNames = append([]string(nil), cs.DNSNames...)
+		change(&changed)
+		if specMatches(changed, true, cert, key, nil) {
+			t.Errorf("%s: changed spec matches the old certificate", name)
+		}
+	}
+	if specMatches(cs, false, cert, key, nil) {
+		t.Errorf("CA matches a leaf spec")
+	}
+
+	leaf := CertSpec{Name: "leaf", CommonName: "leaf", Issuer: "root"}
+	_, _, err = issueSpecCert(leaf, false, &CertKeyPair{Cert: cert, Key: "not a key"})
+	if !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("issued a leaf with an invalid issuer key, got %v", err)
+	}
+
+	// Expired certificates are reissued.
+	tmpl, err := specTemplate(cs, true, "1")
+	if err != nil {
+		t.Fatalf("specTemplate error: %s", err.Error())
+	}
+	tmpl.NotBefore = time.Now().AddDate(-1, 0, -1)
+	tmpl.NotAfter = tmpl.NotBefore.AddDate(1, 0, 0)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.SerialNumber = "1"
+	if specMatches(cs, true, expired, key, nil) {
+		t.Errorf("expired certificate matches the spec")
+	}
+}
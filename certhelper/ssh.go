@@ -0,0 +1,145 @@
+package certhelper
+
+// SSH certificate authority helpers.
+
+import (
+	"crypto"
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHUserCert returns an SSH user certificate for pubKey signed by caPrivKey.
+// principals are the usernames the certificate is valid for.
+// Default values:
+// 	validity = CertValidityConstant. 1 year.
+// 	extensions = SSHUserExtensionsConstant.
+func SSHUserCert(keyID, serialNumber string, principals []string,
+	pubKey, caPrivKey interface{}) (*ssh.Certificate, error) {
+
+	return CustomSSHCert(ssh.UserCert, keyID, serialNumber, principals,
+		CertValidityConstant, nil, SSHUserExtensionsConstant, pubKey, caPrivKey)
+}
+
+// SSHHostCert returns an SSH host certificate for pubKey signed by caPrivKey.
+// principals are the hostnames the certificate is valid for.
+func SSHHostCert(keyID, serialNumber string, principals []string,
+	pubKey, caPrivKey interface{}) (*ssh.Certificate, error) {
+
+	return CustomSSHCert(ssh.HostCert, keyID, serialNumber, principals,
+		CertValidityConstant, nil, nil, pubKey, caPrivKey)
+}
+
+// CustomSSHCert returns a custom SSH certificate signed by caPrivKey.
+// 	certType is ssh.UserCert or ssh.HostCert.
+// 	serialNumber must be a base 10 unsigned integer.
+// 	validity is in years. For example, 1.
+// 	criticalOptions and extensions are copied into the certificate, they can
+// 	be nil. For example, {"force-command": "/bin/true"}.
+// 	pubKey and caPrivKey can be RSA, EC or Ed25519 keys. pubKey can also be a
+// 	private key or an ssh.PublicKey.
+func CustomSSHCert(certType uint32, keyID, serialNumber string,
+	principals []string, validity int, criticalOptions,
+	extensions map[string]string, pubKey, caPrivKey interface{}) (*ssh.Certificate, error) {
+
+	if certType != ssh.UserCert && certType != ssh.HostCert {
+		return nil, fmt.Errorf("certType must be ssh.UserCert or ssh.HostCert, got %d", certType)
+	}
+	// Convert serial number to uint64.
+	sn, err := strconv.ParseUint(serialNumber, 10, 64)
+	if err != nil {
+		return nil, &SerialError{Serial: serialNumber, Err: err}
+	}
+	key, err := SSHPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(caPrivKey)
+	if err != nil {
+		return nil, &KeyTypeError{Param: "caPrivKey", Key: caPrivKey}
+	}
+
+	cert := ssh.Certificate{
+		Key:             key,
+		Serial:          sn,
+		CertType:        certType,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().UTC().Unix()),
+		ValidBefore:     uint64(time.Now().UTC().AddDate(validity, 0, 0).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: copyStringMap(criticalOptions),
+			Extensions:      copyStringMap(extensions),
+		},
+	}
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// SSHPublicKey converts a public or private key (RSA, EC or Ed25519) to an
+// ssh.PublicKey.
+func SSHPublicKey(key interface{}) (ssh.PublicKey, error) {
+	switch k := key.(type) {
+	case ssh.PublicKey:
+		return k, nil
+	case crypto.Signer:
+		return ssh.NewPublicKey(k.Public())
+	default:
+		return ssh.NewPublicKey(k)
+	}
+}
+
+// SSHAuthorizedKey returns the authorized_keys representation of key. key can
+// be anything accepted by SSHPublicKey or an *ssh.Certificate. The result
+// does not have a trailing newline.
+func SSHAuthorizedKey(key interface{}) ([]byte, error) {
+	pub, err := SSHPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	// MarshalAuthorizedKey adds a newline.
+	b := ssh.MarshalAuthorizedKey(pub)
+	return b[:len(b)-1], nil
+}
+
+// SSHAuthorizedKeysCALine returns an authorized_keys line that trusts user
+// certificates signed by caKey. options are extra comma-separated
+// authorized_keys options (e.g., `principals="root"`) and can be empty.
+func SSHAuthorizedKeysCALine(caKey interface{}, options string) ([]byte, error) {
+	k, err := SSHAuthorizedKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	opts := "cert-authority"
+	if options != "" {
+		opts += "," + options
+	}
+	return []byte(opts + " " + string(k) + "\n"), nil
+}
+
+// SSHKnownHostsCALine returns a known_hosts line that trusts host certificates
+// signed by caKey for hosts matching hostPattern (e.g., "*.example.com").
+func SSHKnownHostsCALine(hostPattern string, caKey interface{}) ([]byte, error) {
+	k, err := SSHAuthorizedKey(caKey)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("@cert-authority " + hostPattern + " " + string(k) + "\n"), nil
+}
+
+// copyStringMap returns a copy of m or nil if m is empty.
+func copyStringMap(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
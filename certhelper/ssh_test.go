@@ -0,0 +1,77 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHUserCert(t *testing.T) {
+	// Create CAs with different key types.
+	_, ecCAKey, err := ECRootCA("root1", "org1", "1234", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating EC root CA: %s", err.Error())
+	}
+	_, rsaCAKey, err := RSARootCA("root2", "org1", "1234", "US", 2048)
+	if err != nil {
+		t.Fatalf("error creating RSA root CA: %s", err.Error())
+	}
+	_, edCAKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error creating Ed25519 key: %s", err.Error())
+	}
+	userKey, err := GenerateECKey(CurveP256)
+	if err != nil {
+		t.Fatalf("error creating user key: %s", err.Error())
+	}
+
+	for name, caKey := range map[string]interface{}{
+		"EC": ecCAKey, "RSA": rsaCAKey, "Ed25519": edCAKey} {
+
+		cert, err := SSHUserCert("user1", "10", []string{"user1"}, userKey, caKey)
+		if err != nil {
+			t.Errorf("%s: error in SSHUserCert: %s", name, err.Error())
+			continue
+		}
+		caPub, _ := SSHPublicKey(caKey)
+		checker := ssh.CertChecker{
+			IsUserAuthority: func(auth ssh.PublicKey) bool {
+				return bytes.Equal(auth.Marshal(), caPub.Marshal())
+			},
+		}
+		if err := checker.CheckCert("user1", cert); err != nil {
+			t.Errorf("%s: CheckCert error: %s", name, err.Error())
+		}
+		if err := checker.CheckCert("user2", cert); err == nil {
+			t.Errorf("%s: CheckCert accepted an invalid principal", name)
+		}
+		if cert.Serial != 10 {
+			t.Errorf("%s: Serial error: got %d, want 10", name, cert.Serial)
+		}
+	}
+}
+
+func TestSSHKnownHostsCALine(t *testing.T) {
+	_, caKey, err := ECRootCA("root1", "org1", "1234", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	line, err := SSHKnownHostsCALine("*.example.com", caKey)
+	if err != nil {
+		t.Fatalf("error in SSHKnownHostsCALine: %s", err.Error())
+	}
+	marker, hosts, pub, _, _, err := ssh.ParseKnownHosts(line)
+	if err != nil {
+		t.Fatalf("error parsing known_hosts line: %s", err.Error())
+	}
+	if marker != "cert-authority" || hosts[0] != "*.example.com" {
+		t.Errorf("got marker %s and hosts %v", marker, hosts)
+	}
+	caPub, _ := SSHPublicKey(caKey)
+	if !bytes.Equal(pub.Marshal(), caPub.Marshal()) {
+		t.Errorf("public key mismatch")
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
-	"math/big"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -29,9 +27,11 @@ func CATemplate(commonName, orgUnit, serialNumber, countryCode string,
 // 	MaxPathLenZero is also set to true.
 // 	keyUsage is a mix of https://golang.org/pkg/crypto/x509/#KeyUsage. For example,
 // 	x509.KeyUsageCertSign | x509.KeyUsageCRLSign.
+// 	opts are applied to the template in order. See extensions.go.
 // 	For more customization, manually create a https://golang.org/pkg/crypto/x509/#Certificate.
 func CustomCATemplate(commonName, orgUnit, serialNumber, countryCode, algo string,
-	validity, maxPathLen int, keyUsage x509.KeyUsage) (*x509.Certificate, error) {
+	validity, maxPathLen int, keyUsage x509.KeyUsage,
+	opts ...TemplateOption) (*x509.Certificate, error) {
 
 	cert := x509.Certificate{
 		Subject: pkix.Name{
@@ -60,15 +60,19 @@ func CustomCATemplate(commonName, orgUnit, serialNumber, countryCode, algo strin
 	case "RSA":
 		cert.SignatureAlgorithm = x509.SHA256WithRSA
 	default:
-		return nil, fmt.Errorf("algo must be EC or RSA, got %s", algo)
+		return nil, fmt.Errorf("%w: algo must be EC or RSA, got %s", ErrUnsupportedAlgorithm, algo)
 	}
 
 	// Convert serial number to big int.
-	sn, err := strconv.Atoi(serialNumber)
+	sn, err := parseSerial(serialNumber)
 	if err != nil {
 		return nil, err
 	}
-	cert.SerialNumber = big.NewInt(int64(sn))
+	cert.SerialNumber = sn
+	// Apply options.
+	if err := applyOptions(&cert, opts); err != nil {
+		return nil, err
+	}
 	return &cert, nil
 }
 
@@ -81,8 +85,10 @@ func LeafTemplate(commonName, orgUnit, serialNumber, countryCode string,
 }
 
 // CustomLeafTemplate returns a custom x509.Certificate template for a leaf certificate.
+// opts are applied to the template in order.
 func CustomLeafTemplate(commonName, orgUnit, serialNumber, countryCode, algo string,
-	validity int, keyUsage x509.KeyUsage) (*x509.Certificate, error) {
+	validity int, keyUsage x509.KeyUsage,
+	opts ...TemplateOption) (*x509.Certificate, error) {
 
 	cert := x509.Certificate{
 		Subject: pkix.Name{
@@ -106,14 +112,18 @@ func CustomLeafTemplate(commonName, orgUnit, serialNumber, countryCode, algo str
 	case "RSA":
 		cert.SignatureAlgorithm = x509.SHA256WithRSA
 	default:
-		return nil, fmt.Errorf("algo must be EC or RSA, got %s", algo)
+		return nil, fmt.Errorf("%w: algo must be EC or RSA, got %s", ErrUnsupportedAlgorithm, algo)
 	}
 
 	// Convert serial number to big int.
-	sn, err := strconv.Atoi(serialNumber)
+	sn, err := parseSerial(serialNumber)
 	if err != nil {
 		return nil, err
 	}
-	cert.SerialNumber = big.NewInt(int64(sn))
+	cert.SerialNumber = sn
+	// Apply options.
+	if err := applyOptions(&cert, opts); err != nil {
+		return nil, err
+	}
 	return &cert, nil
 }
@@ -0,0 +1,90 @@
+package certhelper
+
+// TLS test servers.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+)
+
+// NewTLSServer starts an httptest.Server with a leaf certificate for
+// localhost, 127.0.0.1 and ::1 issued by caCert and caPrivKey. If caCert is
+// nil, a new EC root CA is created. Returns the server and a client that
+// trusts the CA. Close the server when done.
+func NewTLSServer(handler http.Handler, caCert *x509.Certificate,
+	caPrivKey interface{}) (*httptest.Server, *http.Client, error) {
+
+	return newTestServer(handler, caCert, caPrivKey, false)
+}
+
+// NewMTLSServer is NewTLSServer with client certificate authentication. The
+// server requires a client certificate issued by the CA and the returned
+// client presents one. Handlers can read it from r.TLS.PeerCertificates.
+func NewMTLSServer(handler http.Handler, caCert *x509.Certificate,
+	caPrivKey interface{}) (*httptest.Server, *http.Client, error) {
+
+	return newTestServer(handler, caCert, caPrivKey, true)
+}
+
+// newTestServer starts the server for NewTLSServer and NewMTLSServer.
+func newTestServer(handler http.Handler, caCert *x509.Certificate,
+	caPrivKey interface{}, mtls bool) (*httptest.Server, *http.Client, error) {
+
+	if caCert == nil {
+		serial, err := randomSerial()
+		if err != nil {
+			return nil, nil, err
+		}
+		caCert, caPrivKey, err = ECRootCA("certhelper test CA", "certhelper",
+			serial, "US", "P256")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	serverCert, err := testServerCert("localhost", x509.ExtKeyUsageServerAuth, caCert, caPrivKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	clientConfig := &tls.Config{RootCAs: roots}
+	if mtls {
+		clientCert, err := testServerCert("client", x509.ExtKeyUsageClientAuth, caCert, caPrivKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		srv.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		srv.TLS.ClientCAs = roots
+		clientConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	srv.StartTLS()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	return srv, client, nil
+}
+
+// testServerCert issues a P-256 leaf for localhost with usage.
+func testServerCert(commonName string, usage x509.ExtKeyUsage, caCert *x509.Certificate,
+	caPrivKey interface{}) (tls.Certificate, error) {
+
+	key, err := GenerateECKey(CurveP256)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, err := CustomLeafCertWithKey(commonName, "certhelper", serial, "US",
+		CertValidityConstant, key, caCert, caPrivKey,
+		WithDNSNames("localhost"), WithIPAddresses("127.0.0.1", "::1"),
+		WithExtKeyUsage(usage))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}, nil
+}
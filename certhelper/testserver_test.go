@@ -0,0 +1,81 @@
+package certhelper
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// peerHandler writes the common name of the client certificate.
+var peerHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if len(r.TLS.PeerCertificates) > 0 {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}
+})
+
+func TestNewTLSServer(t *testing.T) {
+	rsaCA, rsaKey, err := CustomRSARootCA("root1", "org1", "1", "US", 2048, 1, 0,
+		CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating RSA root CA: %s", err.Error())
+	}
+	cas := []struct {
+		name string
+		cert *x509.Certificate
+		key  interface{}
+	}{{"new CA", nil, nil}, {"RSA CA", rsaCA, rsaKey}}
+
+	for _, ca := range cas {
+		srv, client, err := NewTLSServer(peerHandler, ca.cert, ca.key)
+		if err != nil {
+			t.Fatalf("%s: NewTLSServer error: %s", ca.name, err.Error())
+		}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Errorf("%s: GET error: %s", ca.name, err.Error())
+		} else {
+			resp.Body.Close()
+		}
+		// The server does not use the httptest certificate.
+		if _, err := http.Get(srv.URL); err == nil {
+			t.Errorf("%s: default client trusted the server", ca.name)
+		}
+		srv.Close()
+	}
+}
+
+func TestNewMTLSServer(t *testing.T) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	srv, client, err := NewMTLSServer(peerHandler, caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("NewMTLSServer error: %s", err.Error())
+	}
+	defer srv.Close()
+
+	resp, err := client.Get(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1))
+	if err != nil {
+		t.Fatalf("GET error: %s", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "client" {
+		t.Errorf("bad client certificate common name, got %q", body)
+	}
+
+	// Clients without a certificate are rejected.
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	noCert := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if resp, err := noCert.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("server accepted a client without a certificate")
+	}
+}
@@ -0,0 +1,272 @@
+package certhelper
+
+// Trust store management.
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/parsiya/go-utils/filehelper"
+)
+
+// TrustStore is a set of trusted root certificates de-duplicated by their
+// SHA-256 fingerprint.
+type TrustStore struct {
+	certs []*x509.Certificate
+	seen  map[[32]byte]bool
+}
+
+// NewTrustStore returns an empty TrustStore.
+func NewTrustStore() *TrustStore {
+	return &TrustStore{seen: make(map[[32]byte]bool)}
+}
+
+// AddCert adds certificates to the store and returns the number of new ones.
+func (s *TrustStore) AddCert(certs ...*x509.Certificate) int {
+	n := 0
+	for _, c := range certs {
+		fp := sha256.Sum256(c.Raw)
+		if s.seen[fp] {
+			continue
+		}
+		s.seen[fp] = true
+		s.certs = append(s.certs, c)
+		n++
+	}
+	return n
+}
+
+// AddPEM adds all "CERTIFICATE" blocks in data and returns the number of new
+// certificates. Other blocks are ignored.
+func (s *TrustStore) AddPEM(data []byte) (int, error) {
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return n, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return n, err
+		}
+		n += s.AddCert(cert)
+	}
+}
+
+// AddPEMFile adds the certificates in a PEM file.
+func (s *TrustStore) AddPEMFile(filename string) (int, error) {
+	data, err := filehelper.ReadFileByte(filename)
+	if err != nil {
+		return 0, err
+	}
+	return s.AddPEM(data)
+}
+
+// AddDir adds the certificates in all .crt and .pem files in dir. Sub
+// directories are not searched.
+func (s *TrustStore) AddDir(dir string) (int, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, fi := range files {
+		ext := strings.ToLower(filepath.Ext(fi.Name()))
+		if fi.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		added, err := s.AddPEMFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", fi.Name(), err)
+		}
+		n += added
+	}
+	return n, nil
+}
+
+// Certificates returns the certificates in the order they were added.
+func (s *TrustStore) Certificates() []*x509.Certificate {
+	return append([]*x509.Certificate(nil), s.certs...)
+}
+
+// CertPool returns an x509.CertPool with the certificates in the store.
+func (s *TrustStore) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, c := range s.certs {
+		pool.AddCert(c)
+	}
+	return pool
+}
+
+// SystemCertPool returns the system pool with the certificates in the store
+// added to it.
+func (s *TrustStore) SystemCertPool() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range s.certs {
+		pool.AddCert(c)
+	}
+	return pool, nil
+}
+
+// Bundle returns the certificates as concatenated PEM blocks.
+func (s *TrustStore) Bundle() ([]byte, error) {
+	var b bytes.Buffer
+	for _, c := range s.certs {
+		p, err := CertToPEM(c)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(p)
+	}
+	return b.Bytes(), nil
+}
+
+// WriteBundle stores the certificates in a PEM bundle file that can be used as
+// SSL_CERT_FILE. The file is not overwritten.
+func (s *TrustStore) WriteBundle(filename string) error {
+	b, err := s.Bundle()
+	if err != nil {
+		return err
+	}
+	return writeFile(b, filename, false)
+}
+
+// WriteHashDir stores each certificate in dir with the name
+// "<subject hash>.<n>" like c_rehash. The directory can be used as
+// SSL_CERT_DIR. dir is created if it does not exist.
+func (s *TrustStore) WriteHashDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	used := make(map[uint32]int)
+	for _, c := range s.certs {
+		h, err := SubjectHash(c)
+		if err != nil {
+			return err
+		}
+		p, err := CertToPEM(c)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, hashName(h, used[h]))
+		used[h]++
+		if err := writeFile(p, name, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEnv writes a bundle file and a hash directory to dir and returns
+// SSL_CERT_FILE and SSL_CERT_DIR environment variables pointing to them.
+// Append the result to exec.Cmd.Env to make subprocesses such as curl and
+// openssl trust the store. dir is created if it does not exist.
+func (s *TrustStore) WriteEnv(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	bundle := filepath.Join(dir, "ca-bundle.crt")
+	certDir := filepath.Join(dir, "certs")
+	if err := s.WriteBundle(bundle); err != nil {
+		return nil, err
+	}
+	if err := s.WriteHashDir(certDir); err != nil {
+		return nil, err
+	}
+	return []string{"SSL_CERT_FILE=" + bundle, "SSL_CERT_DIR=" + certDir}, nil
+}
+
+// SubjectHash returns the OpenSSL subject name hash of cert. It is the same as
+// the output of "openssl x509 -hash".
+func SubjectHash(cert *x509.Certificate) (uint32, error) {
+	var rdns pkix.RDNSequence
+	if _, err := asn1.Unmarshal(cert.RawSubject, &rdns); err != nil {
+		return 0, err
+	}
+	// The canonical encoding is the DER of each RDN SET without the outer
+	// SEQUENCE.
+	var canon []byte
+	for _, rdn := range rdns {
+		var set []canonAttribute
+		for _, atv := range rdn {
+			value, err := canonValue(atv.Value)
+			if err != nil {
+				return 0, err
+			}
+			set = append(set, canonAttribute{Type: atv.Type, Value: value})
+		}
+		b, err := asn1.MarshalWithParams(set, "set")
+		if err != nil {
+			return 0, err
+		}
+		canon = append(canon, b...)
+	}
+	sum := sha1.Sum(canon)
+	return binary.LittleEndian.Uint32(sum[:4]), nil
+}
+
+// hashName returns the c_rehash file name for the nth certificate with
+// subject hash h.
+func hashName(h uint32, n int) string {
+	return fmt.Sprintf("%08x.%d", h, n)
+}
+
+type canonAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// canonValue converts string values to trimmed, lowercase UTF8Strings with
+// collapsed whitespace like OpenSSL's x509_name_canon.
+func canonValue(v interface{}) (asn1.RawValue, error) {
+	s, ok := v.(string)
+	if !ok {
+		b, err := asn1.Marshal(v)
+		return asn1.RawValue{FullBytes: b}, err
+	}
+	if !utf8.ValidString(s) {
+		return asn1.RawValue{}, fmt.Errorf("invalid UTF-8 in subject")
+	}
+	var b []byte
+	space := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 && isSpace(c) {
+			space = true
+			continue
+		}
+		if space && len(b) > 0 {
+			b = append(b, ' ')
+		}
+		space = false
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagUTF8String, Bytes: b}, nil
+}
+
+// isSpace matches C's isspace.
+func isSpace(c byte) bool {
+	return c == ' ' || (c >= '\t' && c <= '\r')
+}
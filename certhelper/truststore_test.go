@@ -0,0 +1,93 @@
+package certhelper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTrustStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certhelper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ecRoot, ecKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating EC root CA: %s", err.Error())
+	}
+	rsaRoot, _, err := RSARootCA("root2", "org1", "2", "US", 2048)
+	if err != nil {
+		t.Fatalf("error creating RSA root CA: %s", err.Error())
+	}
+	// Store ecRoot in a directory so it is added twice.
+	certDir := filepath.Join(dir, "in")
+	if err := os.Mkdir(certDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := CertToPEMFile(ecRoot, filepath.Join(certDir, "root1.crt")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewTrustStore()
+	if n := s.AddCert(ecRoot, rsaRoot); n != 2 {
+		t.Errorf("AddCert added %d certificates, want 2", n)
+	}
+	n, err := s.AddDir(certDir)
+	if err != nil {
+		t.Fatalf("AddDir error: %s", err.Error())
+	}
+	if n != 0 || len(s.Certificates()) != 2 {
+		t.Errorf("AddDir did not de-duplicate: added %d, total %d", n, len(s.Certificates()))
+	}
+
+	// Leaves issued by the roots should verify against the pool.
+	leaf, _, err := ECLeafCert("leaf1", "org1", "3", "US", "P256", ecRoot, ecKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	if _, err := VerifyChain(leaf, nil, s.Certificates()); err != nil {
+		t.Errorf("leaf did not verify: %s", err.Error())
+	}
+
+	env, err := s.WriteEnv(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatalf("WriteEnv error: %s", err.Error())
+	}
+	if len(env) != 2 {
+		t.Fatalf("WriteEnv returned %v", env)
+	}
+
+	// Read the bundle and hash directory back.
+	s2 := NewTrustStore()
+	if n, err := s2.AddPEMFile(filepath.Join(dir, "out", "ca-bundle.crt")); err != nil || n != 2 {
+		t.Errorf("AddPEMFile error: added %d, %v", n, err)
+	}
+	h, err := SubjectHash(ecRoot)
+	if err != nil {
+		t.Fatalf("SubjectHash error: %s", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out", "certs", hashName(h, 0))); err != nil {
+		t.Errorf("hash directory entry missing: %s", err.Error())
+	}
+}
+
+// The expected value is from "openssl x509 -hash". Case and extra whitespace
+// are ignored.
+func TestSubjectHash(t *testing.T) {
+	for _, cn := range []string{"  My   Root  CA ", "my root ca"} {
+		root, _, err := ECRootCA(cn, "Org  Unit", "1", "US", "P256")
+		if err != nil {
+			t.Fatalf("error creating root CA: %s", err.Error())
+		}
+		h, err := SubjectHash(root)
+		if err != nil {
+			t.Fatalf("SubjectHash error: %s", err.Error())
+		}
+		if got := hashName(h, 0); got != "cedaa1b0.0" {
+			t.Errorf("SubjectHash(%q) = %s, want cedaa1b0.0", cn, got)
+		}
+	}
+}
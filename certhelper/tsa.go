@@ -0,0 +1,303 @@
+package certhelper
+
+// RFC 3161 timestamp tokens.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	oidTSTInfo                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidAttributeTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidAttributeSigningCertV2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidExtKeyUsage             = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtKeyUsageTimeStamping = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+	oidSHA1                    = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA384                  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	defaultTSAPolicyIdentifier = asn1.ObjectIdentifier{1, 2, 3, 4, 1}
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+type essCertIDv2 struct {
+	// HashAlgorithm defaults to SHA-256 and is omitted.
+	CertHash []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// Timestamper returns DER encoded RFC 3161 timestamp tokens for a digest
+// created with hash. TSA and TSAClient implement it.
+type Timestamper interface {
+	Timestamp(digest []byte, hash crypto.Hash) ([]byte, error)
+}
+
+// TimestampInfo is the verified content of a timestamp token.
+type TimestampInfo struct {
+	Time         time.Time
+	SerialNumber *big.Int
+	Policy       asn1.ObjectIdentifier
+	// Nonce is nil if the request did not have one.
+	Nonce *big.Int
+	// Cert is the TSA certificate that signed the token.
+	Cert *x509.Certificate
+}
+
+// TSA is a local timestamp authority that signs tokens in-process. It
+// implements Timestamper.
+type TSA struct {
+	Cert *x509.Certificate
+	// Chain is included in tokens after Cert.
+	Chain []*x509.Certificate
+	// Policy is the TSA policy in the tokens. Default is 1.2.3.4.1 like the
+	// OpenSSL example configuration.
+	Policy asn1.ObjectIdentifier
+
+	key    crypto.Signer
+	mu     sync.Mutex
+	serial *big.Int
+}
+
+// WithTimeStamping sets the TSA profile: digital signature key usage and a
+// critical time stamping extended key usage as required by RFC 3161.
+func WithTimeStamping() TemplateOption {
+	return func(cert *x509.Certificate) error {
+		cert.KeyUsage = x509.KeyUsageDigitalSignature
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+		// Go does not mark the extension critical so add it manually.
+		value, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageTimeStamping})
+		if err != nil {
+			return err
+		}
+		addExtension(cert, pkix.Extension{Id: oidExtKeyUsage, Critical: true, Value: value})
+		return nil
+	}
+}
+
+// NewTSA returns a TSA that signs with cert and privKey. cert must have the
+// time stamping extended key usage, see WithTimeStamping.
+func NewTSA(cert *x509.Certificate, privKey interface{},
+	chain ...*x509.Certificate) (*TSA, error) {
+
+	signer, ok := privKey.(crypto.Signer)
+	if !ok {
+		return nil, &KeyTypeError{Param: "privKey", Key: privKey}
+	}
+	if !hasExtKeyUsage(cert, x509.ExtKeyUsageTimeStamping) {
+		return nil, fmt.Errorf("certificate does not have the time stamping extended key usage")
+	}
+	return &TSA{
+		Cert:   cert,
+		Chain:  chain,
+		Policy: defaultTSAPolicyIdentifier,
+		key:    signer,
+		serial: big.NewInt(0),
+	}, nil
+}
+
+// IssueTSA creates a P-256 TSA certificate signed by caCert with caPrivKey
+// and returns a TSA that uses it. caCert can be an RSA or EC CA, for example
+// from CustomRSARootCA or CustomECRootCA.
+func IssueTSA(commonName, orgUnit, serialNumber, countryCode string,
+	caCert *x509.Certificate, caPrivKey interface{}) (*TSA, error) {
+
+	key, err := GenerateECKey(CurveP256)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := CustomLeafCertWithKey(commonName, orgUnit, serialNumber,
+		countryCode, CertValidityConstant, key, caCert, caPrivKey, WithTimeStamping())
+	if err != nil {
+		return nil, err
+	}
+	return NewTSA(cert, key)
+}
+
+// Timestamp returns a timestamp token for digest.
+func (t *TSA) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	return t.createToken(digest, hash, nil)
+}
+
+// createToken returns a token for digest with an optional nonce.
+func (t *TSA) createToken(digest []byte, hash crypto.Hash, nonce *big.Int) ([]byte, error) {
+	sd, err := t.signedToken(digest, hash, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return marshalSignedData(*sd)
+}
+
+// signedToken returns the SignedData of a token.
+func (t *TSA) signedToken(digest []byte, hash crypto.Hash, nonce *big.Int) (*signedData, error) {
+	hashOID, err := hashToOID(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(digest) != hash.Size() {
+		return nil, fmt.Errorf("invalid digest length, got %d", len(digest))
+	}
+	t.mu.Lock()
+	t.serial.Add(t.serial, big.NewInt(1))
+	serial := new(big.Int).Set(t.serial)
+	t.mu.Unlock()
+
+	info, err := asn1.Marshal(tstInfo{
+		Version: 1,
+		Policy:  t.Policy,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: hashOID, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		SerialNumber: serial,
+		GenTime:      time.Now().UTC(),
+		Accuracy:     accuracy{Seconds: 1},
+		Nonce:        nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+	certHash := sha256.Sum256(t.Cert.Raw)
+	signingCert, err := attributeValue(oidAttributeSigningCertV2, signingCertificateV2{
+		Certs: []essCertIDv2{{CertHash: certHash[:]}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSignedData(info, oidTSTInfo, t.Cert, t.key, t.Chain, false, signingCert)
+}
+
+// VerifyTimestampToken verifies a DER encoded timestamp token for digest. The
+// TSA certificate must have the time stamping extended key usage and chain to
+// roots (the system roots if nil) at the time in the token with the embedded
+// certificates as intermediates.
+func VerifyTimestampToken(token, digest []byte, hash crypto.Hash,
+	roots *x509.CertPool) (*TimestampInfo, error) {
+
+	sd, certs, err := parseSignedData(token)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("content type is not TSTInfo, got %s", sd.EncapContentInfo.EContentType)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("token must have one signer, got %d", len(sd.SignerInfos))
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+		return nil, err
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, err
+	}
+	// Check the message imprint.
+	infoHash, err := oidToHash(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if infoHash != hash || !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, fmt.Errorf("message imprint mismatch")
+	}
+
+	// Check the signature and TSA certificate.
+	cert := findSigner(certs, sd.SignerInfos[0].SID)
+	if cert == nil {
+		return nil, fmt.Errorf("signer certificate not found")
+	}
+	if err := verifySignerInfo(sd.SignerInfos[0], cert, oidTSTInfo, content); err != nil {
+		return nil, err
+	}
+	if !hasExtKeyUsage(cert, x509.ExtKeyUsageTimeStamping) {
+		return nil, fmt.Errorf("certificate does not have the time stamping extended key usage")
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   info.GenTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	if _, err := cert.Verify(opts); err != nil {
+		return nil, err
+	}
+	return &TimestampInfo{
+		Time:         info.GenTime,
+		SerialNumber: info.SerialNumber,
+		Policy:       info.Policy,
+		Nonce:        info.Nonce,
+		Cert:         cert,
+	}, nil
+}
+
+// hasExtKeyUsage returns true if cert has usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// hashToOID returns the OID of a message imprint hash.
+func hashToOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	switch hash {
+	case crypto.SHA1:
+		return oidSHA1, nil
+	case crypto.SHA256:
+		return oidSHA256, nil
+	case crypto.SHA384:
+		return oidSHA384, nil
+	case crypto.SHA512:
+		return oidSHA512, nil
+	default:
+		return nil, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, hash)
+	}
+}
+
+// oidToHash is the inverse of hashToOID.
+func oidToHash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		if o, _ := hashToOID(h); o.Equal(oid) {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: hash %s", ErrUnsupportedAlgorithm, oid)
+}
@@ -0,0 +1,96 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+// testTSA returns a root CA and a TSA issued by it.
+func testTSA(t *testing.T) (*x509.Certificate, interface{}, *TSA) {
+	caCert, caPrivKey, err := ECRootCA("root1", "org1", "1", "US", "P256")
+	if err != nil {
+		t.Fatalf("error creating root CA: %s", err.Error())
+	}
+	cert, key, err := CustomECLeafCert("tsa1", "org1", "2", "US", "P256", 1,
+		caCert, caPrivKey, WithTimeStamping())
+	if err != nil {
+		t.Fatalf("error creating TSA certificate: %s", err.Error())
+	}
+	tsa, err := NewTSA(cert, key)
+	if err != nil {
+		t.Fatalf("NewTSA error: %s", err.Error())
+	}
+	return caCert, caPrivKey, tsa
+}
+
+func TestTSA(t *testing.T) {
+	caCert, caPrivKey, tsa := testTSA(t)
+	ext, ok := CertExtension(tsa.Cert, oidExtKeyUsage)
+	if !ok || !ext.Critical {
+		t.Errorf("TSA certificate does not have a critical extended key usage")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	digest := sha256.Sum256([]byte("hello"))
+	token, err := tsa.Timestamp(digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Timestamp error: %s", err.Error())
+	}
+	info, err := VerifyTimestampToken(token, digest[:], crypto.SHA256, roots)
+	if err != nil {
+		t.Fatalf("VerifyTimestampToken error: %s", err.Error())
+	}
+	if !info.Cert.Equal(tsa.Cert) || info.SerialNumber.Int64() != 1 {
+		t.Errorf("bad token info: %+v", info)
+	}
+	if _, err := tsa.Timestamp(digest[:], crypto.SHA256); err != nil {
+		t.Fatalf("Timestamp error: %s", err.Error())
+	}
+
+	other := sha256.Sum256([]byte("bye"))
+	if _, err := VerifyTimestampToken(token, other[:], crypto.SHA256, roots); err == nil {
+		t.Errorf("token verified for a different digest")
+	}
+	if _, err := VerifyTimestampToken(token, digest[:], crypto.SHA256, x509.NewCertPool()); err == nil {
+		t.Errorf("token verified with the wrong roots")
+	}
+	if _, err := VerifyTimestampToken(token, digest[:], crypto.SHA256, nil); err == nil {
+		t.Errorf("token verified with the system roots")
+	}
+	if _, err := tsa.Timestamp(digest[:16], crypto.SHA256); err == nil {
+		t.Errorf("Timestamp accepted a short digest")
+	}
+
+	// Only time stamping certificates can be used.
+	cert, key, err := ECLeafCert("leaf1", "org1", "3", "US", "P256", caCert, caPrivKey)
+	if err != nil {
+		t.Fatalf("error creating leaf: %s", err.Error())
+	}
+	if _, err := NewTSA(cert, key); err == nil {
+		t.Errorf("NewTSA accepted a certificate without time stamping usage")
+	}
+}
+
+func TestAccuracyEncoding(t *testing.T) {
+	// RFC 3161 tags millis and micros implicitly.
+	der := []byte{0x30, 0x07, 0x02, 0x01, 0x01, 0x80, 0x02, 0x01, 0xF4}
+	var acc accuracy
+	if _, err := asn1.Unmarshal(der, &acc); err != nil {
+		t.Fatalf("error parsing accuracy: %s", err.Error())
+	}
+	if acc.Seconds != 1 || acc.Millis != 500 || acc.Micros != 0 {
+		t.Errorf("bad accuracy, got %+v", acc)
+	}
+	out, err := asn1.Marshal(acc)
+	if err != nil {
+		t.Fatalf("error encoding accuracy: %s", err.Error())
+	}
+	if !bytes.Equal(out, der) {
+		t.Errorf("bad accuracy encoding, got %x", out)
+	}
+}
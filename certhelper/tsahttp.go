@@ -0,0 +1,227 @@
+package certhelper
+
+// RFC 3161 time-stamp protocol over HTTP.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// PKIStatus values.
+const (
+	tsaStatusGranted         = 0
+	tsaStatusGrantedWithMods = 1
+	tsaStatusRejection       = 2
+)
+
+// PKIFailureInfo bits.
+const (
+	tsaFailBadAlg              = 0
+	tsaFailBadRequest          = 2
+	tsaFailBadDataFormat       = 5
+	tsaFailUnacceptedPolicy    = 15
+	tsaFailUnacceptedExtension = 16
+	tsaFailSystemFailure       = 25
+)
+
+const (
+	tsaRequestContentType   = "application/timestamp-query"
+	tsaResponseContentType  = "application/timestamp-reply"
+	maxTimestampMessageSize = 1 << 20
+)
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type pkiStatusInfo struct {
+	Status int
+	// StatusString is a SEQUENCE OF UTF8String.
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// ServeHTTP implements an RFC 3161 TSA. It accepts a DER TimeStampReq in a
+// POST body and returns a TimeStampResp. The token includes the TSA
+// certificate and chain if the request sets certReq.
+func (t *TSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxTimestampMessageSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := asn1.Marshal(t.respond(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", tsaResponseContentType)
+	w.Write(resp)
+}
+
+// respond returns the response for a DER TimeStampReq.
+func (t *TSA) respond(reqDER []byte) timeStampResp {
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(reqDER, &req); err != nil || len(rest) > 0 || req.Version != 1 {
+		return tsaRejection(tsaFailBadRequest, "invalid request")
+	}
+	hash, err := oidToHash(req.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return tsaRejection(tsaFailBadAlg, err.Error())
+	}
+	if len(req.MessageImprint.HashedMessage) != hash.Size() {
+		return tsaRejection(tsaFailBadDataFormat, "invalid message imprint length")
+	}
+	if len(req.ReqPolicy) > 0 && !req.ReqPolicy.Equal(t.Policy) {
+		return tsaRejection(tsaFailUnacceptedPolicy, "unsupported policy "+req.ReqPolicy.String())
+	}
+	if len(req.Extensions) > 0 {
+		return tsaRejection(tsaFailUnacceptedExtension, "extensions are not supported")
+	}
+	sd, err := t.signedToken(req.MessageImprint.HashedMessage, hash, req.Nonce)
+	if err != nil {
+		return tsaRejection(tsaFailSystemFailure, err.Error())
+	}
+	if !req.CertReq {
+		sd.Certificates = asn1.RawValue{}
+	}
+	token, err := marshalSignedData(*sd)
+	if err != nil {
+		return tsaRejection(tsaFailSystemFailure, err.Error())
+	}
+	return timeStampResp{
+		Status:         pkiStatusInfo{Status: tsaStatusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	}
+}
+
+// tsaRejection returns a rejection response with failure bit and reason.
+func tsaRejection(bit int, reason string) timeStampResp {
+	// Named bit strings do not have trailing zero bits in DER.
+	b := make([]byte, bit/8+1)
+	b[bit/8] = 0x80 >> uint(bit%8)
+	return timeStampResp{Status: pkiStatusInfo{
+		Status: tsaStatusRejection,
+		StatusString: []asn1.RawValue{{
+			Class: asn1.ClassUniversal,
+			Tag:   asn1.TagUTF8String,
+			Bytes: []byte(reason),
+		}},
+		FailInfo: asn1.BitString{Bytes: b, BitLength: bit + 1},
+	}}
+}
+
+// TSAClient requests timestamp tokens from an RFC 3161 TSA over HTTP. It
+// implements Timestamper.
+type TSAClient struct {
+	URL string
+	// Client sends the requests. Default is http.DefaultClient.
+	Client *http.Client
+	// Policy is the requested TSA policy. Empty accepts the TSA's default.
+	Policy asn1.ObjectIdentifier
+	// Roots verifies the TSA certificate. If nil, the system roots are used.
+	Roots *x509.CertPool
+}
+
+// NewTSAClient returns a TSAClient for url that verifies tokens with roots.
+func NewTSAClient(url string, roots *x509.CertPool) *TSAClient {
+	return &TSAClient{URL: url, Roots: roots}
+}
+
+// Timestamp requests and verifies a token for digest.
+func (c *TSAClient) Timestamp(digest []byte, hash crypto.Hash) ([]byte, error) {
+	token, _, err := c.Request(digest, hash)
+	return token, err
+}
+
+// Request sends a TimeStampReq with a random nonce for digest and returns the
+// verified token and its content.
+func (c *TSAClient) Request(digest []byte, hash crypto.Hash) ([]byte, *TimestampInfo, error) {
+	hashOID, err := hashToOID(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: hashOID, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		ReqPolicy: c.Policy,
+		Nonce:     nonce,
+		CertReq:   true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpResp, err := client.Post(c.URL, tsaRequestContentType, bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("TSA returned %s", httpResp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(httpResp.Body, maxTimestampMessageSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(body, &resp); err != nil {
+		return nil, nil, err
+	}
+	if s := resp.Status.Status; s != tsaStatusGranted && s != tsaStatusGrantedWithMods {
+		var reasons []string
+		for _, r := range resp.Status.StatusString {
+			reasons = append(reasons, string(r.Bytes))
+		}
+		return nil, nil, fmt.Errorf("TSA rejected the request with status %d: %s", s,
+			strings.Join(reasons, ", "))
+	}
+
+	token := resp.TimeStampToken.FullBytes
+	info, err := VerifyTimestampToken(token, digest, hash, c.Roots)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, nil, fmt.Errorf("nonce mismatch")
+	}
+	if len(c.Policy) > 0 && !info.Policy.Equal(c.Policy) {
+		return nil, nil, fmt.Errorf("policy mismatch, got %s", info.Policy)
+	}
+	return token, info, nil
+}
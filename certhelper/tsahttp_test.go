@@ -0,0 +1,103 @@
+package certhelper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTSAServer(t *testing.T) {
+	rsaCA, rsaKey, err := CustomRSARootCA("root1", "org1", "1", "US", 2048, 1, 0,
+		CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating RSA root CA: %s", err.Error())
+	}
+	ecCA, ecKey, err := CustomECRootCA("root2", "org1", "2", "US", "P256", 1, 0,
+		CAKeyUsageConstant)
+	if err != nil {
+		t.Fatalf("error creating EC root CA: %s", err.Error())
+	}
+	cas := []struct {
+		cert *x509.Certificate
+		key  interface{}
+	}{{rsaCA, rsaKey}, {ecCA, ecKey}}
+
+	for _, ca := range cas {
+		tsa, err := IssueTSA("tsa1", "org1", "3", "US", ca.cert, ca.key)
+		if err != nil {
+			t.Fatalf("IssueTSA error: %s", err.Error())
+		}
+		srv := httptest.NewServer(tsa)
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		client := NewTSAClient(srv.URL, roots)
+
+		digest := sha256.Sum256([]byte("hello"))
+		token, info, err := client.Request(digest[:], crypto.SHA256)
+		if err != nil {
+			t.Fatalf("Request error: %s", err.Error())
+		}
+		if info.Nonce == nil || !info.Cert.Equal(tsa.Cert) {
+			t.Errorf("bad token info: %+v", info)
+		}
+		if _, err := VerifyTimestampToken(token, digest[:], crypto.SHA256, roots); err != nil {
+			t.Errorf("VerifyTimestampToken error: %s", err.Error())
+		}
+
+		// Detached signatures can use the client.
+		cert, key, err := CustomECLeafCert("signer1", "org1", "4", "US", "P256", 1,
+			ecCA, ecKey, WithCodeSigning())
+		if err != nil {
+			t.Fatalf("error creating code signing certificate: %s", err.Error())
+		}
+		sig, err := SignDetached([]byte("data"), cert, key, nil, client)
+		if err != nil {
+			t.Fatalf("SignDetached error: %s", err.Error())
+		}
+		ecRoots := x509.NewCertPool()
+		ecRoots.AddCert(ecCA)
+		if info, err := VerifyDetached([]byte("data"), sig, ecRoots, roots); err != nil ||
+			info.Timestamp == nil {
+			t.Errorf("VerifyDetached error: %v", err)
+		}
+
+		// Rejections.
+		client.Policy = asn1.ObjectIdentifier{1, 2, 3}
+		if _, _, err := client.Request(digest[:], crypto.SHA256); err == nil {
+			t.Errorf("TSA accepted an unknown policy")
+		}
+		client.Policy = nil
+		if _, _, err := client.Request(digest[:10], crypto.SHA256); err == nil {
+			t.Errorf("TSA accepted a short digest")
+		}
+		if _, err := tsa.Timestamp(digest[:], crypto.MD5); err == nil {
+			t.Errorf("TSA accepted MD5")
+		}
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("GET error: %s", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("GET returned %s", resp.Status)
+		}
+		resp, err = http.Post(srv.URL, tsaRequestContentType, bytes.NewReader([]byte("junk")))
+		if err != nil {
+			t.Fatalf("POST error: %s", err.Error())
+		}
+		var tsResp timeStampResp
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if _, err := asn1.Unmarshal(body.Bytes(), &tsResp); err != nil ||
+			tsResp.Status.Status != tsaStatusRejection {
+			t.Errorf("junk request was not rejected: %v", err)
+		}
+		srv.Close()
+	}
+}